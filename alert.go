@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultWebhookRetries = 3
+	webhookTimeout        = 10 * time.Second
+	webhookBackoff        = time.Second
+)
+
+// Alert events sent when a service changes state
+const (
+	eventDown = "down"
+	eventUp   = "up"
+)
+
+const defaultWebhookTemplate = `{
+	"event": {{json .Event}},
+	"service_id": {{json .Service.ID}},
+	"service": {{json .Service.Name}},
+	"endpoint": {{json .Service.Endpoint}},
+	"previous_status": {{json .Previous}},
+	"status": {{json .Check.Status}},
+	"status_code": {{json .Check.StatusCode}},
+	"latency_ms": {{json .Check.Latency.Milliseconds}},
+	"failure": {{json .Check.Category}},
+	"error": {{json .Check.Error}},
+	"time": {{json .Check.Time}}
+}`
+
+// AlertEvent is the data the webhook template is rendered with.
+type AlertEvent struct {
+	Event    string // down or up
+	Service  Service
+	Previous Status
+	Check    Check
+}
+
+// Alerter posts to webhooks when a service goes from up (online or degraded)
+// to offline and back. Services unreachable because a service they depend
+// on is down don't alert, their parent does. Deliveries are retried with
+// backoff and the ones that still fail are recorded in the store.
+// Deliveries still pending when its context is cancelled are abandoned.
+type Alerter struct {
+	ctx      context.Context
+	store    *Store
+	webhooks []string
+	template *template.Template
+	retries  int
+	backoff  time.Duration // Before the first retry, doubled after each one
+	client   *http.Client
+
+	mu   sync.Mutex
+	last map[string]Status // Last status of each service
+	wg   sync.WaitGroup
+}
+
+// NewAlerter creates an alerter for the given webhook URLs. templatePath
+// points to a text/template rendering the JSON payload, the default payload
+// is used when it is blank. Deliveries are cancelled along with ctx.
+func NewAlerter(ctx context.Context, store *Store, webhooks []string, templatePath string, retries int) (*Alerter, error) {
+	text := defaultWebhookTemplate
+	if templatePath != "" {
+		b, err := os.ReadFile(templatePath)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+
+	a := &Alerter{
+		ctx:      ctx,
+		store:    store,
+		webhooks: webhooks,
+		template: tmpl,
+		retries:  max(retries, 0),
+		backoff:  webhookBackoff,
+		client:   &http.Client{Timeout: webhookTimeout},
+		last:     map[string]Status{},
+	}
+
+	// Catch a broken template now rather than on the first outage
+	if _, err := a.render(AlertEvent{Event: eventDown, Previous: StatusOnline, Check: Check{Status: StatusOffline}}); err != nil {
+		return nil, err
+	}
+
+	// Start from the stored history so a transition across restarts is caught
+	services, err := store.GetServices()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range services {
+		for _, c := range s.StatusHistory {
+			if c.Status != StatusUnreachable {
+				a.last[s.ID] = c.Status
+				break
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// Observe detects state transitions in check results, it is meant to be
+// subscribed to the scheduler.
+func (a *Alerter) Observe(r Result) {
+	// The last status is kept so recovering from an outage that was never
+	// alerted doesn't alert either
+	if r.Status == StatusUnreachable {
+		return
+	}
+
+	a.mu.Lock()
+	previous, known := a.last[r.Service.ID]
+	a.last[r.Service.ID] = r.Status
+	a.mu.Unlock()
+
+	if !known || previous.Up() == r.Status.Up() {
+		return
+	}
+
+	event := AlertEvent{Event: eventDown, Service: r.Service, Previous: previous, Check: r.Check}
+	if r.Status.Up() {
+		event.Event = eventUp
+	}
+
+	payload, err := a.render(event)
+	if err != nil {
+		log.Printf("Unable to render alert for service %s: %v", r.Service.ID, err)
+		return
+	}
+
+	// Deliveries must not hold up the scheduler
+	for _, url := range a.webhooks {
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.deliver(url, event, payload)
+		}()
+	}
+}
+
+// Wait blocks until every pending delivery is done.
+func (a *Alerter) Wait() {
+	a.wg.Wait()
+}
+
+func (a *Alerter) render(event AlertEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := a.template.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("webhook template doesn't render valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// deliver posts the payload to a webhook, retrying with exponential backoff
+// until it succeeds, runs out of retries or the alerter is cancelled
+func (a *Alerter) deliver(url string, event AlertEvent, payload []byte) {
+	var err error
+	attempts := 0
+	for attempts <= a.retries && a.ctx.Err() == nil {
+		if attempts > 0 {
+			timer := time.NewTimer(a.backoff << (attempts - 1))
+			select {
+			case <-timer.C:
+			case <-a.ctx.Done():
+				timer.Stop()
+				continue
+			}
+		}
+		attempts++
+		if err = a.post(a.ctx, url, payload); err == nil {
+			return
+		}
+	}
+	if a.ctx.Err() != nil {
+		if err == nil {
+			err = a.ctx.Err()
+		}
+		err = fmt.Errorf("delivery cancelled: %w", err)
+	}
+
+	log.Printf("Unable to deliver %s alert for service %s to %s: %v", event.Event, event.Service.ID, url, err)
+	if err := a.store.SaveAlertFailure(event.Service, url, event.Event, attempts, err); err != nil {
+		log.Printf("Failed to save alert failure for service %s: %v", event.Service.ID, err)
+	}
+}
+
+func (a *Alerter) post(ctx context.Context, url string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestAlerter returns an alerter posting to url with the default template
+// and a short backoff. db is already up so the next offline check alerts.
+func newTestAlerter(t *testing.T, ctx context.Context, url string, retries int) (*Alerter, *Store) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	store := new(Store)
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.conn.Close() })
+	db := Service{ID: "db", Name: "db", Endpoint: "https://db.test"}
+	if err := store.SaveService(db); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewAlerter(ctx, store, []string{url}, "", retries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.backoff = time.Millisecond
+	a.Observe(Result{Service: db, Check: Check{Status: StatusOnline, Time: time.Now()}})
+	return a, store
+}
+
+// alertFailures returns the attempts and error of every recorded failure
+func alertFailures(t *testing.T, store *Store) (attempts []int, errors []string) {
+	t.Helper()
+	rows, err := store.conn.Query(`SELECT attempts, error_message FROM alert_failures ORDER BY id;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n int
+		var message string
+		if err := rows.Scan(&n, &message); err != nil {
+			t.Fatal(err)
+		}
+		attempts = append(attempts, n)
+		errors = append(errors, message)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return attempts, errors
+}
+
+func TestAlerterDelivery(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		failures int32 // Requests answered with an error before a success
+		requests int32
+		failed   bool // Whether the failure is recorded
+	}{
+		{name: "first try", retries: 3, failures: 0, requests: 1},
+		{name: "last retry", retries: 3, failures: 3, requests: 4},
+		{name: "retries exhausted", retries: 2, failures: 3, requests: 3, failed: true},
+		{name: "no retries", retries: 0, failures: 1, requests: 1, failed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			var mu sync.Mutex
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+				}
+				b, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				body = b
+				mu.Unlock()
+				if requests.Add(1) <= tt.failures {
+					w.WriteHeader(http.StatusBadGateway)
+				}
+			}))
+			defer server.Close()
+
+			a, store := newTestAlerter(t, context.Background(), server.URL, tt.retries)
+			db := Service{ID: "db", Name: "db", Endpoint: "https://db.test"}
+			check := Check{Status: StatusOffline, StatusCode: 503, Category: failureStatusCode, Error: "unexpected status", Time: time.Now()}
+			a.Observe(Result{Service: db, Check: check})
+			a.Wait()
+
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("got %d requests, want %d", got, tt.requests)
+			}
+
+			var payload map[string]any
+			if err := json.Unmarshal(body, &payload); err != nil {
+				t.Fatalf("invalid payload %s: %v", body, err)
+			}
+			want := map[string]any{
+				"event":           eventDown,
+				"service_id":      "db",
+				"service":         "db",
+				"endpoint":        "https://db.test",
+				"previous_status": string(StatusOnline),
+				"status":          string(StatusOffline),
+				"status_code":     float64(503),
+				"failure":         failureStatusCode,
+				"error":           "unexpected status",
+			}
+			for key, value := range want {
+				if payload[key] != value {
+					t.Errorf("payload %s = %v, want %v", key, payload[key], value)
+				}
+			}
+
+			attempts, errors := alertFailures(t, store)
+			if !tt.failed {
+				if len(attempts) != 0 {
+					t.Errorf("got failures %v, want none", errors)
+				}
+				return
+			}
+			if len(attempts) != 1 {
+				t.Fatalf("got %d failures, want 1", len(attempts))
+			}
+			if attempts[0] != int(tt.requests) || !strings.Contains(errors[0], "status 502") {
+				t.Errorf("got failure after %d attempts: %s, want %d attempts with status 502", attempts[0], errors[0], tt.requests)
+			}
+		})
+	}
+}
+
+func TestAlerterCancelled(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a, store := newTestAlerter(t, ctx, server.URL, 5)
+	// Long enough that the delivery is cancelled during the first backoff
+	a.backoff = time.Hour
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	a.Observe(Result{Service: Service{ID: "db", Name: "db"}, Check: Check{Status: StatusOffline, Time: time.Now()}})
+	a.Wait()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled delivery took %v", elapsed)
+	}
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+	attempts, errors := alertFailures(t, store)
+	if len(attempts) != 1 {
+		t.Fatalf("got %d failures, want 1", len(attempts))
+	}
+	if attempts[0] != 1 || !strings.HasPrefix(errors[0], "delivery cancelled: ") {
+		t.Errorf("got failure after %d attempts: %s, want a cancelled delivery after 1 attempt", attempts[0], errors[0])
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Assertion sources, the part of the response an assertion looks at
+const (
+	sourceJSON   = "json"
+	sourceBody   = "body" // The raw response body, for HTML and plain text
+	sourceHeader = "header"
+	sourceDNS    = "dns" // The answers of a DNS service
+)
+
+// Assertion operators
+const (
+	opEqual        = "=="
+	opNotEqual     = "!="
+	opLess         = "<"
+	opLessEqual    = "<="
+	opGreater      = ">"
+	opGreaterEqual = ">="
+	opContains     = "contains"
+	opNotContains  = "!contains"
+	opRegex        = "regex"
+	opExists       = "exists"
+)
+
+// Operators supported by each source
+var assertionOperators = map[string][]string{
+	sourceJSON:   {opEqual, opNotEqual, opLess, opLessEqual, opGreater, opGreaterEqual, opContains, opNotContains, opRegex, opExists},
+	sourceBody:   {opContains, opNotContains, opRegex},
+	sourceHeader: {opEqual, opRegex, opExists},
+	sourceDNS:    {opEqual, opNotEqual, opLess, opLessEqual, opGreater, opGreaterEqual, opContains, opNotContains, opRegex},
+}
+
+// Assertion is a check on the response of a service, e.g. a JSONPath
+// compared with an expected value. A service can have several, all of them
+// must hold for the check to succeed.
+type Assertion struct {
+	Source   string
+	Target   string // JSONPath for json assertions, name for header ones, answer, count or ttl for dns ones
+	Operator string
+	Value    string // Expected value, unused by exists
+}
+
+// parseAssertion parses an assertion written as "source [target] operator
+// value", e.g. `json $.checks[0].status == "ok"`, `json $.items exists`,
+// `header Cache-Control regex max-age`, `dns count >= 2` or `body contains
+// healthy`. Body assertions have no target.
+func parseAssertion(line string) (Assertion, error) {
+	var a Assertion
+	line = strings.TrimSpace(line)
+	source, rest, _ := strings.Cut(line, " ")
+	a.Source = strings.ToLower(source)
+
+	switch a.Source {
+	case sourceJSON:
+		a.Target, rest = cutPath(strings.TrimSpace(rest))
+		if _, err := compileJSONPath(a.Target); err != nil {
+			return a, err
+		}
+	case sourceHeader:
+		name, remaining, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		if name == "" || strings.Contains(name, ":") {
+			return a, fmt.Errorf("invalid header name %q", name)
+		}
+		a.Target, rest = http.CanonicalHeaderKey(name), remaining
+	case sourceDNS:
+		target, remaining, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		a.Target, rest = strings.ToLower(target), remaining
+		if _, ok := dnsOperators[a.Target]; !ok {
+			return a, fmt.Errorf("invalid dns assertion target %q (answer, count, ttl)", target)
+		}
+	case sourceBody:
+	default:
+		return a, fmt.Errorf("invalid assertion source %q (json, header, body, dns)", source)
+	}
+
+	operator, value, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	a.Operator = strings.ToLower(operator)
+	a.Value = strings.TrimSpace(value)
+
+	return a, a.validate()
+}
+
+// validate checks the operator and value of an assertion
+func (a Assertion) validate() error {
+	operators := assertionOperators[a.Source]
+	if a.Source == sourceDNS {
+		operators = dnsOperators[a.Target]
+	}
+	if !slices.Contains(operators, a.Operator) {
+		return fmt.Errorf("invalid %s assertion operator %q (%s)", a.Source, a.Operator, strings.Join(operators, ", "))
+	}
+	if a.Operator != opExists && a.Value == "" {
+		return fmt.Errorf("assertion operator %s needs a value", a.Operator)
+	}
+	if a.Operator == opRegex {
+		if _, err := regexp.Compile(a.Value); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+	if a.Source == sourceDNS && (a.Target == dnsCount || a.Target == dnsTTL) {
+		if n, err := strconv.Atoi(a.Value); err != nil || n < 0 {
+			return fmt.Errorf("%s must be compared with a whole number", a.Target)
+		}
+	}
+	return nil
+}
+
+// supports reports whether a service of the given type can evaluate the
+// assertion
+func (a Assertion) supports(serviceType string) bool {
+	switch serviceType {
+	case typeDNS:
+		return a.Source == sourceDNS
+	case typeTCP, typeICMP, typeGRPC:
+		return false
+	case typeMultiStep:
+		// Assertions go on the response of each step instead
+		return false
+	case typeWebSocket:
+		// Only the reply message can be asserted on
+		return a.Source == sourceBody || a.Source == sourceJSON
+	default:
+		return a.Source != sourceDNS
+	}
+}
+
+// cutPath splits a JSONPath from the rest of an assertion line. The path
+// ends at the first space outside brackets and quotes, as filters can
+// contain spaces.
+func cutPath(s string) (string, string) {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ' ' && depth == 0:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+func (a Assertion) String() string {
+	parts := []string{a.Source}
+	if a.Target != "" {
+		parts = append(parts, a.Target)
+	}
+	parts = append(parts, a.Operator)
+	if a.Value != "" {
+		parts = append(parts, a.Value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// sourceAssertions returns the assertions of a service on the given source
+func (s Service) sourceAssertions(source string) []Assertion {
+	assertions := []Assertion{}
+	for _, a := range s.Assertions {
+		if a.Source == source {
+			assertions = append(assertions, a)
+		}
+	}
+	return assertions
+}
+
+// jsonAssertions returns the json assertions of a service, starting with
+// the one described by its JSON property and expected value
+func (s Service) jsonAssertions() []Assertion {
+	assertions := []Assertion{}
+	if s.JSONProperty != "" {
+		legacy := Assertion{Source: sourceJSON, Target: s.JSONProperty, Operator: opExists}
+		if s.ExpectedValue != "" {
+			legacy.Operator = opEqual
+			legacy.Value = s.ExpectedValue
+		}
+		assertions = append(assertions, legacy)
+	}
+	return append(assertions, s.sourceAssertions(sourceJSON)...)
+}
+
+// evalBody checks a body assertion against the raw response body. The
+// expected text may be quoted to keep surrounding spaces.
+func (a Assertion) evalBody(body []byte) error {
+	text := a.Value
+	if literal, ok := parseLiteral(a.Value); ok {
+		if s, ok := literal.(string); ok {
+			text = s
+		}
+	}
+
+	switch a.Operator {
+	case opContains:
+		if !bytes.Contains(body, []byte(text)) {
+			return fmt.Errorf("body doesn't contain %q", text)
+		}
+	case opNotContains:
+		if bytes.Contains(body, []byte(text)) {
+			return fmt.Errorf("body contains %q", text)
+		}
+	case opRegex:
+		re, err := regexp.Compile(a.Value)
+		if err != nil {
+			return err
+		}
+		if !re.Match(body) {
+			return fmt.Errorf("body doesn't match %s", a.Value)
+		}
+	default:
+		return fmt.Errorf("invalid body assertion operator %q", a.Operator)
+	}
+	return nil
+}
+
+// evalHeader checks a header assertion against the response headers. When
+// the header is repeated, one matching value is enough.
+func (a Assertion) evalHeader(header http.Header) error {
+	values := header.Values(a.Target)
+	if len(values) == 0 {
+		return fmt.Errorf("header %s missing", a.Target)
+	}
+
+	switch a.Operator {
+	case opExists:
+		return nil
+	case opEqual:
+		text := a.Value
+		if literal, ok := parseLiteral(a.Value); ok {
+			if s, ok := literal.(string); ok {
+				text = s
+			}
+		}
+		if slices.Contains(values, text) {
+			return nil
+		}
+	case opRegex:
+		re, err := regexp.Compile(a.Value)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(values, re.MatchString) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("invalid header assertion operator %q", a.Operator)
+	}
+	return fmt.Errorf("header %s is %q, expected %s %s", a.Target, strings.Join(values, ", "), a.Operator, a.Value)
+}
+
+// evalJSON checks a json assertion against a decoded document. When the
+// path selects several nodes, one match is enough, except for != which must
+// hold for all of them.
+func (a Assertion) evalJSON(doc any) error {
+	path, err := compileJSONPath(a.Target)
+	if err != nil {
+		return err
+	}
+
+	nodes := path.eval(doc)
+	if len(nodes) == 0 {
+		return fmt.Errorf("%s not found", a.Target)
+	}
+	if a.Operator == opExists {
+		return nil
+	}
+
+	for _, node := range nodes {
+		ok, err := compareValues(node, a.Operator, a.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", a.Target, err)
+		}
+		if ok && a.Operator != opNotEqual {
+			return nil
+		}
+		if !ok && a.Operator == opNotEqual {
+			return fmt.Errorf("%s is %s, expected %s %s", a.Target, textOf(node), a.Operator, a.Value)
+		}
+	}
+	if a.Operator == opNotEqual {
+		return nil
+	}
+	return fmt.Errorf("%s is %s, expected %s %s", a.Target, textOf(nodes[0]), a.Operator, a.Value)
+}
+
+// compareValues compares a decoded JSON value with an expected value written
+// as text. The expected value is read as a JSON literal when it is one, so
+// 42, true and null compare with numbers, booleans and null, while strings
+// can be written with or without quotes.
+func compareValues(actual any, op, expected string) (bool, error) {
+	literal, isLiteral := parseLiteral(expected)
+	text := expected
+	if s, ok := literal.(string); ok && isLiteral {
+		text = s
+	}
+
+	switch op {
+	case opEqual, opNotEqual:
+		equal := equalValues(actual, literal, isLiteral, text)
+		return equal == (op == opEqual), nil
+
+	case opLess, opLessEqual, opGreater, opGreaterEqual:
+		var cmp int
+		a, aNum := toNumber(actual)
+		e, eNum := toNumber(literal)
+		switch {
+		case aNum && eNum:
+			cmp = a.Cmp(e)
+		case !aNum:
+			s, ok := actual.(string)
+			if !ok {
+				return false, fmt.Errorf("%s can't be compared with %s", textOf(actual), op)
+			}
+			cmp = strings.Compare(s, text)
+		default:
+			return false, fmt.Errorf("%s is not a number", expected)
+		}
+		switch op {
+		case opLess:
+			return cmp < 0, nil
+		case opLessEqual:
+			return cmp <= 0, nil
+		case opGreater:
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+
+	case opNotContains:
+		contains, err := compareValues(actual, opContains, expected)
+		return !contains, err
+
+	case opContains:
+		switch a := actual.(type) {
+		case string:
+			return strings.Contains(a, text), nil
+		case []any:
+			for _, item := range a {
+				if equalValues(item, literal, isLiteral, text) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			_, ok := a[text]
+			return ok, nil
+		default:
+			return false, fmt.Errorf("%s can't contain a value", textOf(actual))
+		}
+
+	case opRegex:
+		re, err := regexp.Compile(expected)
+		if err != nil {
+			return false, err
+		}
+		if s, ok := actual.(string); ok {
+			return re.MatchString(s), nil
+		}
+		return re.MatchString(textOf(actual)), nil
+
+	case opExists:
+		return true, nil
+	}
+
+	return false, fmt.Errorf("invalid operator %q", op)
+}
+
+func equalValues(actual, literal any, isLiteral bool, text string) bool {
+	switch a := actual.(type) {
+	case string:
+		return a == text
+	case nil:
+		return isLiteral && literal == nil
+	case bool:
+		b, ok := literal.(bool)
+		return ok && a == b
+	case json.Number, float64:
+		x, _ := toNumber(a)
+		y, ok := toNumber(literal)
+		return ok && x.Cmp(y) == 0
+	default:
+		// Arrays and objects compare by their canonical JSON encoding
+		if !isLiteral {
+			return false
+		}
+		return textOf(a) == textOf(literal)
+	}
+}
+
+// parseLiteral reads a JSON literal, reporting false when s isn't one
+func parseLiteral(s string) (any, bool) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return s, false
+	}
+	return v, true
+}
+
+// toNumber converts decoded JSON numbers, and strings holding one, to an
+// exact rational so large integers don't lose precision
+func toNumber(v any) (*big.Rat, bool) {
+	var s string
+	switch n := v.(type) {
+	case json.Number:
+		s = n.String()
+	case float64:
+		return new(big.Rat).SetFloat64(n), true
+	case string:
+		s = n
+	default:
+		return nil, false
+	}
+	r, ok := new(big.Rat).SetString(s)
+	return r, ok
+}
+
+// textOf renders a decoded JSON value for messages and regex matching
+func textOf(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// decodeJSON decodes a response body keeping numbers exact
+func decodeJSON(body []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON document")
+	}
+	return doc, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCompareValues(t *testing.T) {
+	tests := []struct {
+		actual   string // JSON encoded
+		op       string
+		expected string
+		want     bool
+		wantErr  bool
+	}{
+		// Numbers compare exactly, whatever their notation
+		{`42`, opEqual, `42`, true, false},
+		{`42`, opEqual, `42.0`, true, false},
+		{`4.2e1`, opEqual, `42`, true, false},
+		{`42`, opNotEqual, `43`, true, false},
+		{`9007199254740993`, opEqual, `9007199254740992`, false, false},
+		{`10`, opLess, `9`, false, false},
+		{`10`, opGreater, `9`, true, false},
+		{`10`, opLessEqual, `10`, true, false},
+		{`10`, opGreaterEqual, `10.5`, false, false},
+		{`-1.5`, opLess, `0`, true, false},
+
+		// Booleans
+		{`true`, opEqual, `true`, true, false},
+		{`false`, opEqual, `true`, false, false},
+		{`false`, opNotEqual, `true`, true, false},
+
+		// Strings, quoted or not
+		{`"ok"`, opEqual, `ok`, true, false},
+		{`"ok"`, opEqual, `"ok"`, true, false},
+		{`" ok "`, opEqual, `" ok "`, true, false},
+		{`"ok"`, opNotEqual, `fail`, true, false},
+		{`"abc"`, opLess, `abd`, true, false},
+		{`"healthy"`, opContains, `health`, true, false},
+		{`"healthy"`, opNotContains, `sick`, true, false},
+		{`"v1.2.3"`, opRegex, `^v\d+\.\d+`, true, false},
+		{`"v1.2.3"`, opRegex, `(`, false, true},
+
+		// Null
+		{`null`, opEqual, `null`, true, false},
+		{`null`, opNotEqual, `null`, false, false},
+		{`null`, opEqual, `"null"`, false, false},
+
+		// Mismatched types, strings compare with the text of the literal and
+		// numbers with strings holding one
+		{`"42"`, opEqual, `42`, true, false},
+		{`42`, opEqual, `"42"`, true, false},
+		{`42`, opEqual, `true`, false, false},
+		{`true`, opEqual, `1`, false, false},
+		{`true`, opEqual, `"true"`, false, false},
+		{`0`, opEqual, `null`, false, false},
+		{`null`, opEqual, `0`, false, false},
+		{`""`, opEqual, `null`, false, false},
+		{`[1,2]`, opEqual, `1`, false, false},
+		{`{"a":1}`, opEqual, `{"a":1}`, true, false},
+		{`{"a":1}`, opEqual, `{a:1}`, false, false},
+
+		// Ordering needs numbers or strings
+		{`42`, opLess, `abc`, false, true},
+		{`true`, opGreater, `1`, false, true},
+		{`null`, opLess, `1`, false, true},
+		{`"10"`, opLess, `9`, false, false}, // Both read as numbers
+
+		// Containment
+		{`[1,2,3]`, opContains, `2`, true, false},
+		{`[1,2,3]`, opContains, `"2"`, true, false},
+		{`["a","b"]`, opContains, `c`, false, false},
+		{`{"a":1}`, opContains, `a`, true, false},
+		{`{"a":1}`, opNotContains, `a`, false, false},
+		{`42`, opContains, `4`, false, true},
+
+		// Exists ignores the value
+		{`null`, opExists, ``, true, false},
+		{`1`, "~", `1`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.actual+" "+tt.op+" "+tt.expected, func(t *testing.T) {
+			actual, err := decodeJSON([]byte(tt.actual))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := compareValues(actual, tt.op, tt.expected)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compareValues(%s, %s, %s) error = %v, want error %v", tt.actual, tt.op, tt.expected, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("compareValues(%s, %s, %s) = %v, want %v", tt.actual, tt.op, tt.expected, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalBody(t *testing.T) {
+	body := []byte("<html><title>Status</title><p>All systems  operational</p><p>build 1.24.3</p></html>")
+
+	tests := []struct {
+		assertion string
+		wantErr   string // Blank when the assertion holds
+	}{
+		{`body contains operational`, ""},
+		{`body contains All systems  operational`, ""},
+		{`body contains " operational<"`, ""},
+		{`body contains "<title>Status</title>"`, ""},
+		{`body contains degraded`, `body doesn't contain "degraded"`},
+		{`body contains Operational`, `body doesn't contain "Operational"`},
+		{`body !contains error`, ""},
+		{`body !contains systems`, `body contains "systems"`},
+		{`body regex build \d+\.\d+\.\d+`, ""},
+		{`body regex (?i)all SYSTEMS`, ""},
+		{`body regex ^<html>.*</html>$`, ""},
+		{`body regex build 2\.`, `body doesn't match build 2\.`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.assertion, func(t *testing.T) {
+			a, err := parseAssertion(tt.assertion)
+			if err != nil {
+				t.Fatalf("parseAssertion(%q): %v", tt.assertion, err)
+			}
+			got := ""
+			if err := a.evalBody(body); err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("evalBody() = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvalHeader(t *testing.T) {
+	header := http.Header{
+		"Content-Type":  {"application/json; charset=utf-8"},
+		"Cache-Control": {"no-cache", "max-age=60"},
+		"X-Version":     {" 2.1 "},
+	}
+
+	tests := []struct {
+		assertion string
+		wantErr   string // Blank when the assertion holds
+	}{
+		{`header content-type exists`, ""},
+		{`header X-Missing exists`, "header X-Missing missing"},
+		{`header Content-Type == application/json; charset=utf-8`, ""},
+		{`header Content-Type == application/json`, `header Content-Type is "application/json; charset=utf-8", expected == application/json`},
+		{`header X-Version == " 2.1 "`, ""},
+		{`header X-Version == 2.1`, `header X-Version is " 2.1 ", expected == 2.1`},
+		{`header Cache-Control == max-age=60`, ""},
+		{`header Cache-Control regex ^max-age=\d+$`, ""},
+		{`header Content-Type regex ^application/json`, ""},
+		{`header Content-Type regex ^text/`, `header Content-Type is "application/json; charset=utf-8", expected regex ^text/`},
+		{`header X-Missing regex .*`, "header X-Missing missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.assertion, func(t *testing.T) {
+			a, err := parseAssertion(tt.assertion)
+			if err != nil {
+				t.Fatalf("parseAssertion(%q): %v", tt.assertion, err)
+			}
+			got := ""
+			if err := a.evalHeader(header); err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("evalHeader() = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+
+	// Every failed header assertion is reported
+	s := Service{Assertions: parseAssertions(t, "header X-Missing exists", "header Content-Type exists", "header X-Version == 3")}
+	want := `header X-Missing missing; header X-Version is " 2.1 ", expected == 3`
+	if err := s.evalHeaders(header); err == nil || err.Error() != want {
+		t.Errorf("evalHeaders() = %v, want %q", err, want)
+	}
+}
+
+func TestParseHeaderAssertionErrors(t *testing.T) {
+	for _, line := range []string{
+		"header",
+		"header Content-Type: exists",
+		"header Content-Type contains json",
+		"header Content-Type ==",
+		"header Content-Type regex (",
+	} {
+		if a, err := parseAssertion(line); err == nil {
+			t.Errorf("parseAssertion(%q) = %s, want an error", line, a)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rules combining the members of a composite service. A rule can also be
+// the number of members that must be up, a quorum.
+const (
+	ruleAll = "all" // Every member must be up
+	ruleAny = "any" // One member up is enough
+)
+
+// serviceCheck is the latest check of a service, e.g. a member of a
+// composite service or a parent of a service
+type serviceCheck struct {
+	Name  string
+	Check Check // Zero until the member is checked
+}
+
+// getCompositeStatus grades a composite service from the latest checks of
+// its members, without making any request. It is offline when fewer members
+// than its rule needs are up and online when every member is online, anything
+// in between is degraded.
+func getCompositeStatus(s Service, members []serviceCheck) Check {
+	check := Check{Time: time.Now()}
+	if len(members) == 0 {
+		return check.fail(failureMembers, errors.New("no members"))
+	}
+
+	up, online := 0, 0
+	problems := []string{}
+	for _, m := range members {
+		switch {
+		case m.Check.Time.IsZero():
+			problems = append(problems, m.Name+" not checked yet")
+		case m.Check.Status == StatusOnline:
+			up++
+			online++
+		case m.Check.Status.Up():
+			up++
+			problems = append(problems, m.Name+" "+string(m.Check.Status))
+		default:
+			problems = append(problems, m.Name+" "+string(m.Check.Status))
+		}
+	}
+
+	needed := quorum(s.Rule, len(members))
+	summary := fmt.Sprintf("%d of %d members up, %d needed: %s", up, len(members), needed, strings.Join(problems, ", "))
+	if up < needed {
+		return check.fail(failureMembers, errors.New(summary))
+	}
+	check.Status = StatusOnline
+	if online < len(members) {
+		check.Status = StatusDegraded
+		check.Category = failureMembers
+		check.Error = truncate(summary, maxErrorMessage)
+	}
+	return check
+}
+
+// quorum returns the number of members that must be up for a composite
+// service with the given rule to be up. A blank rule means all.
+func quorum(rule string, members int) int {
+	switch rule {
+	case "", ruleAll:
+		return members
+	case ruleAny:
+		return 1
+	}
+	n, err := strconv.Atoi(rule)
+	if err != nil || n <= 0 {
+		return members
+	}
+	return n
+}
+
+// validRule reports whether rule is blank, all, any or a quorum
+func validRule(rule string) bool {
+	if rule == "" || rule == ruleAll || rule == ruleAny {
+		return true
+	}
+	n, err := strconv.Atoi(rule)
+	return err == nil && n > 0
+}
+
+// validateComposite checks the rule of a composite service against its
+// number of members
+func validateComposite(rule string, members int) error {
+	if members == 0 {
+		return errors.New("composite services need at least one member")
+	}
+	if !validRule(rule) {
+		return fmt.Errorf("invalid rule %q (all, any or a number of members)", rule)
+	}
+	if quorum(rule, members) > members {
+		return fmt.Errorf("rule %s is more than the number of members (%d)", rule, members)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompositeStatus(t *testing.T) {
+	now := time.Now()
+	member := func(name string, status Status) serviceCheck {
+		return serviceCheck{Name: name, Check: Check{Status: status, Time: now}}
+	}
+	var (
+		apiOn  = member("api", StatusOnline)
+		apiOff = member("api", StatusOffline)
+		dbOn   = member("db", StatusOnline)
+		dbDeg  = member("db", StatusDegraded)
+		dbOff  = member("db", StatusOffline)
+		cache  = member("cache", StatusUnreachable)
+		queue  = serviceCheck{Name: "queue"} // Never checked
+	)
+
+	tests := []struct {
+		name    string
+		rule    string
+		members []serviceCheck
+		want    Status
+		message string // Blank when every member is online
+	}{
+		{name: "all online", rule: ruleAll, members: []serviceCheck{apiOn, dbOn}, want: StatusOnline},
+		{name: "blank rule is all", members: []serviceCheck{apiOn, dbOff}, want: StatusOffline, message: "1 of 2 members up, 2 needed: db offline"},
+		{name: "all with a degraded member", rule: ruleAll, members: []serviceCheck{apiOn, dbDeg}, want: StatusDegraded, message: "2 of 2 members up, 2 needed: db degraded"},
+		{name: "all with a member down", rule: ruleAll, members: []serviceCheck{apiOn, dbOff}, want: StatusOffline, message: "1 of 2 members up, 2 needed: db offline"},
+		{name: "any with one up", rule: ruleAny, members: []serviceCheck{apiOff, dbDeg}, want: StatusDegraded, message: "1 of 2 members up, 1 needed: api offline, db degraded"},
+		{name: "any with none up", rule: ruleAny, members: []serviceCheck{apiOff, dbOff}, want: StatusOffline, message: "0 of 2 members up, 1 needed: api offline, db offline"},
+		{name: "quorum reached", rule: "2", members: []serviceCheck{apiOn, dbOn, cache}, want: StatusDegraded, message: "2 of 3 members up, 2 needed: cache unreachable"},
+		{name: "quorum missed", rule: "2", members: []serviceCheck{apiOn, dbOff, cache}, want: StatusOffline, message: "1 of 3 members up, 2 needed: db offline, cache unreachable"},
+		{name: "never checked counts as down", rule: ruleAll, members: []serviceCheck{apiOn, queue}, want: StatusOffline, message: "1 of 2 members up, 2 needed: queue not checked yet"},
+		{name: "never checked with any", rule: ruleAny, members: []serviceCheck{queue, dbOn}, want: StatusDegraded, message: "1 of 2 members up, 1 needed: queue not checked yet"},
+		{name: "no members", rule: ruleAny, want: StatusOffline, message: "no members"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := getCompositeStatus(Service{Rule: tt.rule}, tt.members)
+			if check.Status != tt.want {
+				t.Errorf("status = %s, want %s", check.Status, tt.want)
+			}
+			if check.Error != tt.message {
+				t.Errorf("error = %q, want %q", check.Error, tt.message)
+			}
+			category := ""
+			if tt.message != "" {
+				category = failureMembers
+			}
+			if check.Category != category {
+				t.Errorf("category = %q, want %q", check.Category, category)
+			}
+		})
+	}
+}
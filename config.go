@@ -0,0 +1,666 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the declarative service list read from a YAML or JSON file.
+type Config struct {
+	Services []ServiceConfig `yaml:"services" json:"services"`
+}
+
+// ServiceConfig describes a service in the config file. Its fields mirror
+// the Service columns. Services are identified by name, including the
+// members of composite services and the services others depend on.
+type ServiceConfig struct {
+	Name               string            `yaml:"name" json:"name"`
+	Type               string            `yaml:"type" json:"type"`
+	Method             string            `yaml:"method" json:"method"`
+	Endpoint           string            `yaml:"endpoint" json:"endpoint"`
+	Payload            string            `yaml:"payload" json:"payload"`
+	Headers            map[string]string `yaml:"headers" json:"headers"`
+	Auth               AuthConfig        `yaml:"auth" json:"auth"`
+	RequestDelay       scalar            `yaml:"request_delay" json:"request_delay"`
+	Timeout            scalar            `yaml:"timeout" json:"timeout"`
+	DegradedThreshold  scalar            `yaml:"degraded_threshold" json:"degraded_threshold"`
+	DownThreshold      scalar            `yaml:"down_threshold" json:"down_threshold"`
+	JSONProperty       string            `yaml:"json_property" json:"json_property"`
+	ExpectedValue      scalar            `yaml:"expected_value" json:"expected_value"`
+	Assertions         []string          `yaml:"assertions" json:"assertions"`
+	PreferredStatus    scalar            `yaml:"preferred_status" json:"preferred_status"`
+	InsecureSkipVerify scalar            `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+	CertWarningDays    scalar            `yaml:"cert_warning_days" json:"cert_warning_days"`
+	CertCriticalDays   scalar            `yaml:"cert_critical_days" json:"cert_critical_days"`
+	Banner             string            `yaml:"banner" json:"banner"`
+	Resolver           string            `yaml:"resolver" json:"resolver"`
+	RecordType         string            `yaml:"record_type" json:"record_type"`
+	PingCount          scalar            `yaml:"ping_count" json:"ping_count"`
+	GRPCService        string            `yaml:"grpc_service" json:"grpc_service"`
+	GRPCTLS            scalar            `yaml:"grpc_tls" json:"grpc_tls"`
+	Steps              []StepConfig      `yaml:"steps" json:"steps"`
+	Members            []string          `yaml:"members" json:"members"`
+	Rule               scalar            `yaml:"rule" json:"rule"`
+	DependsOn          []string          `yaml:"depends_on" json:"depends_on"`
+	Retries            scalar            `yaml:"retries" json:"retries"`
+	RetryBackoff       scalar            `yaml:"retry_backoff" json:"retry_backoff"`
+	FailureThreshold   scalar            `yaml:"failure_threshold" json:"failure_threshold"`
+	RecoveryThreshold  scalar            `yaml:"recovery_threshold" json:"recovery_threshold"`
+}
+
+// StepConfig describes a request of a multistep service. Extractions are
+// written as "name = source target".
+type StepConfig struct {
+	Name            string            `yaml:"name" json:"name"`
+	Method          string            `yaml:"method" json:"method"`
+	Endpoint        string            `yaml:"endpoint" json:"endpoint"`
+	Payload         string            `yaml:"payload" json:"payload"`
+	Headers         map[string]string `yaml:"headers" json:"headers"`
+	PreferredStatus scalar            `yaml:"preferred_status" json:"preferred_status"`
+	Extract         []string          `yaml:"extract" json:"extract"`
+	Assertions      []string          `yaml:"assertions" json:"assertions"`
+}
+
+type AuthConfig struct {
+	Type     string `yaml:"type" json:"type"`
+	Username string `yaml:"username" json:"username"`
+	Secret   string `yaml:"secret" json:"secret"`
+	Header   string `yaml:"header" json:"header"`
+}
+
+// scalar is a string that can also be written as a number or a boolean in
+// the config file, as Service stores every value as text.
+type scalar string
+
+func (v *scalar) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a single value", node.Line)
+	}
+	*v = scalar(node.Value)
+	return nil
+}
+
+func (v *scalar) UnmarshalJSON(b []byte) error {
+	var value any
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case nil:
+		*v = ""
+	case string:
+		*v = scalar(value)
+	case float64:
+		*v = scalar(strconv.FormatFloat(value, 'f', -1, 64))
+	case bool:
+		*v = scalar(strconv.FormatBool(value))
+	default:
+		return fmt.Errorf("expected a single value, got %s", b)
+	}
+	return nil
+}
+
+// ConfigReport lists the services changed by a config sync, by name.
+type ConfigReport struct {
+	Added   []string
+	Updated []string
+	Removed []string
+}
+
+func (r ConfigReport) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d removed", len(r.Added), len(r.Updated), len(r.Removed))
+}
+
+// LoadConfig reads a config file, picking the format from its extension.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(strings.NewReader(string(b)))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&config)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(b)))
+		dec.KnownFields(true)
+		err = dec.Decode(&config)
+	default:
+		return config, fmt.Errorf("unsupported config format %q (.yaml, .yml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return config, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i, sc := range config.Services {
+		if strings.TrimSpace(sc.Name) == "" {
+			return config, fmt.Errorf("service #%d: name cannot be empty", i+1)
+		}
+		if seen[sc.Name] {
+			return config, fmt.Errorf("service %s: defined more than once", sc.Name)
+		}
+		seen[sc.Name] = true
+		members := map[string]bool{}
+		for _, name := range sc.Members {
+			if strings.TrimSpace(name) == strings.TrimSpace(sc.Name) {
+				return config, fmt.Errorf("service %s: can't be a member of itself", sc.Name)
+			}
+			if members[name] {
+				return config, fmt.Errorf("service %s: member %s listed more than once", sc.Name, name)
+			}
+			members[name] = true
+		}
+		parents := map[string]bool{}
+		for _, name := range sc.DependsOn {
+			if strings.TrimSpace(name) == strings.TrimSpace(sc.Name) {
+				return config, fmt.Errorf("service %s: can't depend on itself", sc.Name)
+			}
+			if parents[name] {
+				return config, fmt.Errorf("service %s: depends on %s more than once", sc.Name, name)
+			}
+			parents[name] = true
+		}
+		for _, line := range sc.Assertions {
+			if _, err := parseAssertion(line); err != nil {
+				return config, fmt.Errorf("service %s: invalid assertion %q: %w", sc.Name, line, err)
+			}
+		}
+		for j, step := range sc.Steps {
+			for _, line := range step.Extract {
+				if _, err := parseExtraction(line); err != nil {
+					return config, fmt.Errorf("service %s: step %d: invalid extraction %q: %w", sc.Name, j+1, line, err)
+				}
+			}
+			for _, line := range step.Assertions {
+				if _, err := parseAssertion(line); err != nil {
+					return config, fmt.Errorf("service %s: step %d: invalid assertion %q: %w", sc.Name, j+1, line, err)
+				}
+			}
+		}
+		if err := validateService(sc.service()); err != nil {
+			return config, fmt.Errorf("service %s: %w", sc.Name, err)
+		}
+	}
+
+	return config, nil
+}
+
+// service converts the config entry to a Service. Environment variables in
+// the credentials and header values are expanded so secrets can stay out of
+// the file.
+func (sc ServiceConfig) service() Service {
+	s := Service{
+		Name:               strings.TrimSpace(sc.Name),
+		Type:               strings.ToLower(strings.TrimSpace(sc.Type)),
+		Method:             strings.ToUpper(strings.TrimSpace(sc.Method)),
+		Endpoint:           strings.TrimSpace(sc.Endpoint),
+		Payload:            sc.Payload,
+		RequestDelay:       strings.TrimSpace(string(sc.RequestDelay)),
+		Timeout:            strings.TrimSpace(string(sc.Timeout)),
+		DegradedThreshold:  strings.TrimSpace(string(sc.DegradedThreshold)),
+		DownThreshold:      strings.TrimSpace(string(sc.DownThreshold)),
+		JSONProperty:       strings.TrimSpace(sc.JSONProperty),
+		ExpectedValue:      strings.TrimSpace(string(sc.ExpectedValue)),
+		PreferredStatus:    strings.TrimSpace(string(sc.PreferredStatus)),
+		InsecureSkipVerify: strings.ToLower(strings.TrimSpace(string(sc.InsecureSkipVerify))),
+		AuthType:           strings.ToLower(strings.TrimSpace(sc.Auth.Type)),
+		AuthUsername:       os.ExpandEnv(sc.Auth.Username),
+		AuthSecret:         os.ExpandEnv(sc.Auth.Secret),
+		AuthHeader:         strings.TrimSpace(sc.Auth.Header),
+		CertWarningDays:    strings.TrimSpace(string(sc.CertWarningDays)),
+		CertCriticalDays:   strings.TrimSpace(string(sc.CertCriticalDays)),
+		Banner:             strings.TrimSpace(sc.Banner),
+		Resolver:           strings.TrimSpace(sc.Resolver),
+		RecordType:         strings.ToUpper(strings.TrimSpace(sc.RecordType)),
+		PingCount:          strings.TrimSpace(string(sc.PingCount)),
+		GRPCService:        strings.TrimSpace(sc.GRPCService),
+		GRPCTLS:            strings.ToLower(strings.TrimSpace(string(sc.GRPCTLS))),
+		Headers:            []Header{},
+		Assertions:         []Assertion{},
+		Rule:               strings.ToLower(strings.TrimSpace(string(sc.Rule))),
+		Retries:            strings.TrimSpace(string(sc.Retries)),
+		RetryBackoff:       strings.TrimSpace(string(sc.RetryBackoff)),
+		FailureThreshold:   strings.TrimSpace(string(sc.FailureThreshold)),
+		RecoveryThreshold:  strings.TrimSpace(string(sc.RecoveryThreshold)),
+		Steps:              []Step{},
+		Members:            []string{},
+		Parents:            []string{},
+		Managed:            "true",
+	}
+
+	// Fill in the same defaults as the wizard
+	if s.Type == "" {
+		s.Type = typeHTTP
+	}
+	if s.Type == typeHTTP {
+		if s.Method == "" {
+			s.Method = "GET"
+		}
+		if s.PreferredStatus == "" {
+			s.PreferredStatus = "200"
+		}
+	}
+	if s.Type == typeHTTP || s.Type == typeWebSocket || s.Type == typeMultiStep {
+		if s.InsecureSkipVerify == "" {
+			s.InsecureSkipVerify = "false"
+		}
+		if s.AuthType == "" {
+			s.AuthType = authNone
+		}
+	}
+	if s.Type == typeComposite && s.Rule == "" {
+		s.Rule = ruleAll
+	}
+	if s.Type == typeDNS && s.RecordType == "" {
+		s.RecordType = defaultRecordType
+	}
+	if s.Type == typeGRPC {
+		if s.GRPCTLS == "" {
+			s.GRPCTLS = "false"
+		}
+		if s.InsecureSkipVerify == "" {
+			s.InsecureSkipVerify = "false"
+		}
+	}
+
+	// Invalid assertions are reported by LoadConfig
+	for _, line := range sc.Assertions {
+		if a, err := parseAssertion(line); err == nil {
+			s.Assertions = append(s.Assertions, a)
+		}
+	}
+
+	// Maps have no order, sort headers to keep saves stable
+	names := make([]string, 0, len(sc.Headers))
+	for name := range sc.Headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		s.Headers = append(s.Headers, Header{Name: name, Value: os.ExpandEnv(sc.Headers[name])})
+	}
+
+	for _, stc := range sc.Steps {
+		s.Steps = append(s.Steps, stc.step())
+	}
+	// The first step stands for the service in the list and in alerts
+	if s.Type == typeMultiStep && len(s.Steps) > 0 {
+		s.Endpoint = s.Steps[0].Endpoint
+	}
+
+	// Members and parents are kept by name until SyncConfig resolves them
+	// to IDs
+	for _, name := range sc.Members {
+		s.Members = append(s.Members, strings.TrimSpace(name))
+	}
+	for _, name := range sc.DependsOn {
+		s.Parents = append(s.Parents, strings.TrimSpace(name))
+	}
+
+	return s
+}
+
+// step converts the config entry of a step, expanding environment
+// variables in its header values like service headers.
+func (stc StepConfig) step() Step {
+	step := Step{
+		Name:            strings.TrimSpace(stc.Name),
+		Method:          strings.ToUpper(strings.TrimSpace(stc.Method)),
+		Endpoint:        strings.TrimSpace(stc.Endpoint),
+		Payload:         stc.Payload,
+		PreferredStatus: strings.TrimSpace(string(stc.PreferredStatus)),
+		Headers:         []Header{},
+		Extractions:     []Extraction{},
+		Assertions:      []Assertion{},
+	}
+	if step.Method == "" {
+		step.Method = "GET"
+	}
+
+	names := make([]string, 0, len(stc.Headers))
+	for name := range stc.Headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		step.Headers = append(step.Headers, Header{Name: name, Value: os.ExpandEnv(stc.Headers[name])})
+	}
+
+	// Invalid extractions and assertions are reported by LoadConfig
+	for _, line := range stc.Extract {
+		if e, err := parseExtraction(line); err == nil {
+			step.Extractions = append(step.Extractions, e)
+		}
+	}
+	for _, line := range stc.Assertions {
+		if a, err := parseAssertion(line); err == nil {
+			step.Assertions = append(step.Assertions, a)
+		}
+	}
+	return step
+}
+
+// validateService checks a service the way the wizard does
+func validateService(s Service) error {
+	switch s.Type {
+	case typeHTTP:
+		if err := validateHTTPService(s); err != nil {
+			return err
+		}
+	case typeTCP:
+		if !validTCPEndpoint(s.Endpoint) {
+			return fmt.Errorf("invalid endpoint %q (host:port)", s.Endpoint)
+		}
+		if _, err := regexp.Compile(s.Banner); err != nil {
+			return fmt.Errorf("invalid banner: %w", err)
+		}
+		if len(s.Headers) > 0 || s.JSONProperty != "" {
+			return fmt.Errorf("tcp services don't support headers or a json_property")
+		}
+	case typeDNS:
+		if !validDomain(s.Endpoint) {
+			return fmt.Errorf("invalid endpoint %q (domain name)", s.Endpoint)
+		}
+		if !validResolver(s.Resolver) {
+			return fmt.Errorf("invalid resolver %q (host or host:port)", s.Resolver)
+		}
+		if !slices.Contains(dnsRecordTypes, s.RecordType) {
+			return fmt.Errorf("invalid record_type %q (%s)", s.RecordType, strings.Join(dnsRecordTypes, ", "))
+		}
+		if len(s.Headers) > 0 || s.JSONProperty != "" {
+			return fmt.Errorf("dns services don't support headers or a json_property")
+		}
+	case typeICMP:
+		if !validHost(s.Endpoint) {
+			return fmt.Errorf("invalid endpoint %q (host name or IP address)", s.Endpoint)
+		}
+		if !validPingCount(s.PingCount) {
+			return fmt.Errorf("invalid ping_count %q (1-%d)", s.PingCount, maxPingCount)
+		}
+		if len(s.Headers) > 0 || s.JSONProperty != "" {
+			return fmt.Errorf("icmp services don't support headers or a json_property")
+		}
+	case typeWebSocket:
+		if !validWebSocketEndpoint(s.Endpoint) {
+			return fmt.Errorf("invalid endpoint %q (ws:// or wss://)", s.Endpoint)
+		}
+		if err := validateRequestOptions(s); err != nil {
+			return err
+		}
+	case typeMultiStep:
+		if err := validateSteps(s.Steps); err != nil {
+			return err
+		}
+		if len(s.Headers) > 0 || len(s.Assertions) > 0 || s.JSONProperty != "" || s.AuthType != authNone {
+			return fmt.Errorf("multistep services set headers, auth and assertions on their steps")
+		}
+		if err := validateRequestOptions(s); err != nil {
+			return err
+		}
+	case typeGRPC:
+		if !validTCPEndpoint(s.Endpoint) {
+			return fmt.Errorf("invalid endpoint %q (host:port)", s.Endpoint)
+		}
+		for name, value := range map[string]string{
+			"grpc_tls":             s.GRPCTLS,
+			"insecure_skip_verify": s.InsecureSkipVerify,
+		} {
+			if value != "true" && value != "false" {
+				return fmt.Errorf("invalid %s %q (true/false)", name, value)
+			}
+		}
+		for name, value := range map[string]string{
+			"cert_warning_days":  s.CertWarningDays,
+			"cert_critical_days": s.CertCriticalDays,
+		} {
+			if !validDays(value) {
+				return fmt.Errorf("invalid %s %q (days)", name, value)
+			}
+		}
+		warning := daysValue(s.CertWarningDays, defaultCertWarningDays)
+		critical := daysValue(s.CertCriticalDays, defaultCertCriticalDays)
+		if s.GRPCTLS == "true" && warning > 0 && critical >= warning {
+			return fmt.Errorf("cert_critical_days must be lower than cert_warning_days")
+		}
+		for _, h := range s.Headers {
+			if _, err := parseHeader(h.Name + ": " + h.Value); err != nil {
+				return fmt.Errorf("invalid metadata %q", h.Name)
+			}
+		}
+		if s.AuthType != "" || s.JSONProperty != "" {
+			return fmt.Errorf("grpc services don't support auth or a json_property")
+		}
+	case typeComposite:
+		if err := validateComposite(s.Rule, len(s.Members)); err != nil {
+			return err
+		}
+		if s.Endpoint != "" || len(s.Headers) > 0 || len(s.Assertions) > 0 || s.JSONProperty != "" || s.AuthType != "" {
+			return fmt.Errorf("composite services make no requests, they only have members and a rule")
+		}
+		if s.Retries != "" || s.RetryBackoff != "" {
+			return fmt.Errorf("composite services make no requests to retry")
+		}
+	default:
+		return fmt.Errorf("invalid type %q (http, tcp, dns, icmp, grpc, websocket, multistep, composite)", s.Type)
+	}
+	if s.Type != typeMultiStep && len(s.Steps) > 0 {
+		return fmt.Errorf("only multistep services have steps")
+	}
+	if s.Type != typeComposite && (len(s.Members) > 0 || s.Rule != "") {
+		return fmt.Errorf("only composite services have members and a rule")
+	}
+	for _, a := range s.Assertions {
+		if !a.supports(s.Type) {
+			return fmt.Errorf("%s assertions don't apply to %s services", a.Source, s.Type)
+		}
+	}
+
+	for name, value := range map[string]string{
+		"request_delay":      s.RequestDelay,
+		"timeout":            s.Timeout,
+		"degraded_threshold": s.DegradedThreshold,
+		"down_threshold":     s.DownThreshold,
+		"retry_backoff":      s.RetryBackoff,
+	} {
+		if !validMilliseconds(value) {
+			return fmt.Errorf("invalid %s %q (milliseconds)", name, value)
+		}
+	}
+	if !validRetries(s.Retries) {
+		return fmt.Errorf("invalid retries %q (0-%d)", s.Retries, maxRetries)
+	}
+	for name, value := range map[string]string{
+		"failure_threshold":  s.FailureThreshold,
+		"recovery_threshold": s.RecoveryThreshold,
+	} {
+		if !validThreshold(value) {
+			return fmt.Errorf("invalid %s %q (1-%d checks)", name, value, maxConfirmThreshold)
+		}
+	}
+	if s.DegradedThreshold != "" && s.DownThreshold != "" &&
+		msDuration(s.DownThreshold, 0) <= msDuration(s.DegradedThreshold, 0) {
+		return fmt.Errorf("down_threshold must be greater than degraded_threshold")
+	}
+	return nil
+}
+
+// validateHTTPService checks the HTTP specific settings of a service
+func validateHTTPService(s Service) error {
+	if !slices.Contains(httpMethods, s.Method) {
+		return fmt.Errorf("invalid method %q (%s)", s.Method, strings.Join(httpMethods, ", "))
+	}
+	if !strings.HasPrefix(s.Endpoint, "http://") && !strings.HasPrefix(s.Endpoint, "https://") {
+		return fmt.Errorf("invalid endpoint %q (http:// or https://)", s.Endpoint)
+	}
+	if _, err := parseStatusCodes(s.PreferredStatus); err != nil {
+		return fmt.Errorf("invalid preferred_status %q (e.g. 200, 200,204, 2xx or 200-299)", s.PreferredStatus)
+	}
+	if s.bodilessMethod() && s.hasContentAssertions() {
+		return fmt.Errorf("%s responses have no body for a json_property or body and json assertions", s.Method)
+	}
+	return validateRequestOptions(s)
+}
+
+// validateRequestOptions checks the settings HTTP and WebSocket services
+// share: headers, auth, the JSON property and TLS verification.
+func validateRequestOptions(s Service) error {
+	for _, h := range s.Headers {
+		if _, err := parseHeader(h.Name + ": " + h.Value); err != nil {
+			return fmt.Errorf("invalid header %q", h.Name)
+		}
+	}
+	switch s.AuthType {
+	case authNone:
+	case authBasic:
+		if s.AuthUsername == "" || s.AuthSecret == "" {
+			return fmt.Errorf("basic auth needs a username and a secret")
+		}
+	case authBearer, authAPIKey:
+		if s.AuthSecret == "" {
+			return fmt.Errorf("%s auth needs a secret", s.AuthType)
+		}
+	default:
+		return fmt.Errorf("invalid auth type %q (none, basic, bearer, apikey)", s.AuthType)
+	}
+	if s.JSONProperty != "" {
+		if _, err := compileJSONPath(s.JSONProperty); err != nil {
+			return fmt.Errorf("invalid json_property: %w", err)
+		}
+	}
+	if s.InsecureSkipVerify != "true" && s.InsecureSkipVerify != "false" {
+		return fmt.Errorf("invalid insecure_skip_verify %q (true/false)", s.InsecureSkipVerify)
+	}
+	for name, value := range map[string]string{
+		"cert_warning_days":  s.CertWarningDays,
+		"cert_critical_days": s.CertCriticalDays,
+	} {
+		if !validDays(value) {
+			return fmt.Errorf("invalid %s %q (days)", name, value)
+		}
+	}
+	warning := daysValue(s.CertWarningDays, defaultCertWarningDays)
+	if critical := daysValue(s.CertCriticalDays, defaultCertCriticalDays); warning > 0 && critical >= warning {
+		return fmt.Errorf("cert_critical_days must be lower than cert_warning_days")
+	}
+	return nil
+}
+
+// SyncConfig makes the stored services match the config file. Services are
+// matched by name: new ones are added, changed ones updated and services
+// previously added from the file but no longer in it are removed. Services
+// created from the wizard are left alone unless the file takes them over by
+// name. Members of composite services and the services others depend on can
+// be services of the file or ones created from the wizard.
+func (s *Store) SyncConfig(config Config) (ConfigReport, error) {
+	var report ConfigReport
+
+	services, err := s.GetServices()
+	if err != nil {
+		return report, err
+	}
+	byName := map[string]Service{}
+	for _, service := range services {
+		byName[service.Name] = service
+	}
+
+	// New services get their ID up front so other services can refer to
+	// them before they're saved
+	ids := map[string]string{}
+	for _, service := range services {
+		if service.Managed != "true" {
+			ids[service.Name] = service.ID
+		}
+	}
+	for _, sc := range config.Services {
+		name := strings.TrimSpace(sc.Name)
+		ids[name] = uuid.New().String()
+		if existing, ok := byName[name]; ok {
+			ids[name] = existing.ID
+		}
+	}
+	// References are checked before saving anything to leave the store
+	// untouched
+	defined := map[string]bool{}
+	configured := []Service{}
+	for _, sc := range config.Services {
+		service := sc.service()
+		service.ID = ids[service.Name]
+		defined[service.Name] = true
+		for i, name := range service.Members {
+			id, ok := ids[name]
+			if !ok {
+				return report, fmt.Errorf("service %s: unknown member %s", service.Name, name)
+			}
+			service.Members[i] = id
+		}
+		for i, name := range service.Parents {
+			id, ok := ids[name]
+			if !ok {
+				return report, fmt.Errorf("service %s: unknown dependency %s", service.Name, name)
+			}
+			service.Parents[i] = id
+		}
+		configured = append(configured, service)
+	}
+	all := slices.Clone(configured)
+	for _, service := range services {
+		if service.Managed != "true" && !defined[service.Name] {
+			all = append(all, service)
+		}
+	}
+	if err := dependencyCycle(all); err != nil {
+		return report, err
+	}
+
+	// Everything is saved at once so a failure leaves the store as it was
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	for _, service := range configured {
+		existing, ok := byName[service.Name]
+		if ok && sameConfig(existing, service) {
+			continue
+		}
+
+		if err := saveService(tx, service); err != nil {
+			return report, fmt.Errorf("unable to save service %s: %w", service.Name, err)
+		}
+		if ok {
+			report.Updated = append(report.Updated, service.Name)
+		} else {
+			report.Added = append(report.Added, service.Name)
+		}
+	}
+
+	for _, service := range services {
+		if service.Managed != "true" || defined[service.Name] {
+			continue
+		}
+		if err := deleteService(tx, service); err != nil {
+			return report, fmt.Errorf("unable to remove service %s: %w", service.Name, err)
+		}
+		report.Removed = append(report.Removed, service.Name)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
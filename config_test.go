@@ -0,0 +1,260 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file named name to a temporary directory and
+// returns its path
+func writeConfig(t *testing.T, name, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("GOARDIAN_TEST_TOKEN", "s3cret")
+
+	yamlConfig := `services:
+  - name: " api "
+    endpoint: https://api.test/health
+    timeout: 500
+    headers:
+      X-Token: $GOARDIAN_TEST_TOKEN
+    assertions:
+      - body contains ok
+  - name: db
+    type: tcp
+    endpoint: db.test:5432
+  - name: stack
+    type: composite
+    members: [api, db]
+    rule: 1
+`
+	jsonConfig := `{"services": [{"name": "api", "endpoint": "https://api.test/health", "timeout": 500}]}`
+
+	config, err := LoadConfig(writeConfig(t, "goardian.yaml", yamlConfig))
+	if err != nil {
+		t.Fatalf("LoadConfig(yaml): %v", err)
+	}
+	if len(config.Services) != 3 {
+		t.Fatalf("got %d services, want 3", len(config.Services))
+	}
+	api := config.Services[0].service()
+	if api.Name != "api" || api.Type != typeHTTP || api.Method != "GET" || api.PreferredStatus != "200" || api.Timeout != "500" {
+		t.Errorf("api read as %+v", api)
+	}
+	if len(api.Headers) != 1 || api.Headers[0].Value != "s3cret" {
+		t.Errorf("api headers = %v, want X-Token expanded", api.Headers)
+	}
+	if len(api.Assertions) != 1 || api.Assertions[0].Source != "body" {
+		t.Errorf("api assertions = %v", api.Assertions)
+	}
+	if stack := config.Services[2].service(); stack.Rule != "1" || !slices.Equal(stack.Members, []string{"api", "db"}) {
+		t.Errorf("stack read with rule %q and members %v", stack.Rule, stack.Members)
+	}
+
+	config, err = LoadConfig(writeConfig(t, "goardian.json", jsonConfig))
+	if err != nil {
+		t.Fatalf("LoadConfig(json): %v", err)
+	}
+	if len(config.Services) != 1 || config.Services[0].Timeout != "500" {
+		t.Errorf("json config read as %+v", config.Services)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		text string
+		want string // Part of the error
+	}{
+		{"format", "goardian.toml", ``, "unsupported config format"},
+		{"unknown field", "goardian.yaml", "services:\n  - name: api\n    url: https://api.test\n", "field url not found"},
+		{"unknown json field", "goardian.json", `{"services": [{"name": "api", "url": "https://api.test"}]}`, `unknown field "url"`},
+		{"no name", "goardian.yaml", "services:\n  - endpoint: https://api.test\n", "service #1: name cannot be empty"},
+		{"duplicate", "goardian.yaml", "services:\n  - name: api\n    endpoint: https://a.test\n  - name: api\n    endpoint: https://b.test\n", "defined more than once"},
+		{"own member", "goardian.yaml", "services:\n  - name: all\n    type: composite\n    members: [all]\n", "can't be a member of itself"},
+		{"own dependency", "goardian.yaml", "services:\n  - name: api\n    endpoint: https://api.test\n    depends_on: [api]\n", "can't depend on itself"},
+		{"assertion", "goardian.yaml", "services:\n  - name: api\n    endpoint: https://api.test\n    assertions: [body like ok]\n", "invalid assertion"},
+		{"invalid service", "goardian.yaml", "services:\n  - name: api\n    endpoint: api.test\n", "service api: invalid endpoint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tt.file, tt.text))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateService(t *testing.T) {
+	tests := []struct {
+		name    string
+		service ServiceConfig
+		want    string // Part of the error, blank when valid
+	}{
+		{"http", ServiceConfig{Endpoint: "https://api.test", Method: "post", PreferredStatus: "2xx"}, ""},
+		{"http method", ServiceConfig{Endpoint: "https://api.test", Method: "FETCH"}, "invalid method"},
+		{"http status", ServiceConfig{Endpoint: "https://api.test", PreferredStatus: "ok"}, "invalid preferred_status"},
+		{"head with body assertion", ServiceConfig{Endpoint: "https://api.test", Method: "HEAD", Assertions: []string{"body contains ok"}}, "have no body"},
+		{"basic auth", ServiceConfig{Endpoint: "https://api.test", Auth: AuthConfig{Type: "basic", Username: "admin"}}, "needs a username and a secret"},
+		{"cert days", ServiceConfig{Endpoint: "https://api.test", CertWarningDays: "7", CertCriticalDays: "14"}, "cert_critical_days must be lower"},
+		{"tcp", ServiceConfig{Type: "tcp", Endpoint: "db.test:5432", Banner: "^220"}, ""},
+		{"tcp endpoint", ServiceConfig{Type: "tcp", Endpoint: "db.test"}, "invalid endpoint"},
+		{"tcp banner", ServiceConfig{Type: "tcp", Endpoint: "db.test:5432", Banner: "("}, "invalid banner"},
+		{"grpc", ServiceConfig{Type: "grpc", Endpoint: "api.test:50051", GRPCTLS: "true", CertWarningDays: "30", CertCriticalDays: "7"}, ""},
+		{"grpc cert days", ServiceConfig{Type: "grpc", Endpoint: "api.test:50051", GRPCTLS: "true", CertWarningDays: "7", CertCriticalDays: "7"}, "cert_critical_days must be lower"},
+		{"grpc cert days without tls", ServiceConfig{Type: "grpc", Endpoint: "api.test:50051", CertWarningDays: "7", CertCriticalDays: "14"}, ""},
+		{"dns record", ServiceConfig{Type: "dns", Endpoint: "example.test", RecordType: "SPF"}, "invalid record_type"},
+		{"composite rule", ServiceConfig{Type: "composite", Members: []string{"a", "b"}, Rule: "2"}, ""},
+		{"composite quorum", ServiceConfig{Type: "composite", Members: []string{"a", "b"}, Rule: "3"}, "more than the number of members"},
+		{"composite endpoint", ServiceConfig{Type: "composite", Members: []string{"a"}, Endpoint: "https://api.test"}, "make no requests"},
+		{"members outside composite", ServiceConfig{Endpoint: "https://api.test", Members: []string{"a"}}, "only composite services"},
+		{"type", ServiceConfig{Type: "ftp", Endpoint: "ftp.test"}, "invalid type"},
+		{"timeout", ServiceConfig{Endpoint: "https://api.test", Timeout: "soon"}, "invalid timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.service.Name = tt.name
+			err := validateService(tt.service.service())
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if tt.want == "" && err != nil || !strings.Contains(got, tt.want) {
+				t.Errorf("validateService() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+	store := new(Store)
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.conn.Close()
+
+	wizard := Service{Name: "wizard", Type: typeHTTP, Method: "GET", Endpoint: "https://wizard.test", PreferredStatus: "200"}
+	if err := store.SaveService(wizard); err != nil {
+		t.Fatal(err)
+	}
+
+	sync := func(text string) ConfigReport {
+		t.Helper()
+		config, err := LoadConfig(writeConfig(t, "goardian.yaml", text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := store.SyncConfig(config)
+		if err != nil {
+			t.Fatalf("SyncConfig: %v", err)
+		}
+		return report
+	}
+	stored := func() map[string]Service {
+		t.Helper()
+		services, err := store.GetServices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		byName := map[string]Service{}
+		for _, s := range services {
+			byName[s.Name] = s
+		}
+		return byName
+	}
+	checkReport := func(got ConfigReport, added, updated, removed []string) {
+		t.Helper()
+		if !slices.Equal(got.Added, added) || !slices.Equal(got.Updated, updated) || !slices.Equal(got.Removed, removed) {
+			t.Errorf("got %+v, want added %v, updated %v, removed %v", got, added, updated, removed)
+		}
+	}
+
+	// Add, with references to a file service and to a wizard service
+	report := sync(`services:
+  - name: api
+    endpoint: https://api.test
+  - name: db
+    type: tcp
+    endpoint: db.test:5432
+  - name: stack
+    type: composite
+    members: [api, wizard]
+    depends_on: [db]
+`)
+	checkReport(report, []string{"api", "db", "stack"}, nil, nil)
+	services := stored()
+	if len(services) != 4 {
+		t.Fatalf("got %d services, want 4", len(services))
+	}
+	if stack := services["stack"]; !slices.Equal(stack.Members, []string{services["api"].ID, services["wizard"].ID}) || !slices.Equal(stack.Parents, []string{services["db"].ID}) {
+		t.Errorf("stack has members %v and parents %v", stack.Members, stack.Parents)
+	}
+	if services["api"].Managed != "true" || services["wizard"].Managed == "true" {
+		t.Errorf("api managed %q, wizard managed %q", services["api"].Managed, services["wizard"].Managed)
+	}
+
+	// Unchanged services aren't saved again, changed ones keep their ID
+	apiID := services["api"].ID
+	report = sync(`services:
+  - name: api
+    endpoint: https://api.test/v2
+  - name: db
+    type: tcp
+    endpoint: db.test:5432
+  - name: stack
+    type: composite
+    members: [api, wizard]
+    depends_on: [db]
+`)
+	checkReport(report, nil, []string{"api"}, nil)
+	services = stored()
+	if api := services["api"]; api.ID != apiID || api.Endpoint != "https://api.test/v2" {
+		t.Errorf("api updated to %s %s, want %s https://api.test/v2", api.ID, api.Endpoint, apiID)
+	}
+
+	// Removed services go with the references to them, the wizard service
+	// stays even though the file doesn't list it
+	report = sync(`services:
+  - name: api
+    endpoint: https://api.test/v2
+`)
+	checkReport(report, nil, nil, []string{"db", "stack"})
+	services = stored()
+	if len(services) != 2 {
+		t.Fatalf("got services %v, want api and wizard", services)
+	}
+	if _, ok := services["wizard"]; !ok {
+		t.Error("wizard service was removed")
+	}
+
+	// Unknown references fail without touching the store
+	config, err := LoadConfig(writeConfig(t, "goardian.yaml", `services:
+  - name: web
+    endpoint: https://web.test
+  - name: all
+    type: composite
+    members: [web, missing]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SyncConfig(config); err == nil || !strings.Contains(err.Error(), "unknown member missing") {
+		t.Errorf("SyncConfig() error = %v, want an unknown member", err)
+	}
+	if services := stored(); len(services) != 2 {
+		t.Errorf("failed sync left %d services, want 2", len(services))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Interval at which headless mode picks up services changed in the store
+const headlessReloadInterval = time.Minute
+
+// runHeadless checks the stored services without the TUI, logging every
+// result until SIGINT or SIGTERM is received.
+func runHeadless(ctx context.Context, store *Store, scheduler *Scheduler) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	scheduler.Subscribe(logResult)
+	if err := scheduler.Start(ctx); err != nil {
+		return err
+	}
+
+	services, err := store.GetServices()
+	if err != nil {
+		return err
+	}
+	log.Printf("goardian running headless, checking %d services", len(services))
+
+	ticker := time.NewTicker(headlessReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("shutting down, waiting for running checks")
+			scheduler.Stop()
+			return nil
+		case <-ticker.C:
+			if err := scheduler.Reload(); err != nil {
+				log.Printf("unable to reload services: %v", err)
+			}
+		}
+	}
+}
+
+// logResult writes a check result as a single log line
+func logResult(r Result) {
+	line := "service=%q id=%s status=%s latency=%v"
+	args := []any{r.Service.Name, r.Service.ID, r.Status, r.Latency.Round(time.Millisecond)}
+	if r.StatusCode != 0 {
+		line += " code=%d"
+		args = append(args, r.StatusCode)
+	}
+	if r.Category != "" {
+		line += " failure=%s error=%q"
+		args = append(args, r.Category, r.Error)
+	}
+	log.Printf(line, args...)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// unreachable turns a failed check of a service whose parents are down into
+// an unreachable one, keeping its own failure in the message. Checks that
+// passed, and the ones of services whose parents are up or not checked yet,
+// are returned as is.
+func unreachable(check Check, parents []serviceCheck) Check {
+	if check.Status.Up() {
+		return check
+	}
+	down := []string{}
+	for _, p := range parents {
+		if !p.Check.Time.IsZero() && !p.Check.Status.Up() {
+			down = append(down, p.Name)
+		}
+	}
+	if len(down) == 0 {
+		return check
+	}
+
+	message := strings.Join(down, ", ") + " down"
+	if check.Category != "" {
+		message += " (" + check.Category + ": " + check.Error + ")"
+	}
+	check.Status = StatusUnreachable
+	check.Category = failureDependency
+	check.Error = truncate(message, maxErrorMessage)
+	return check
+}
+
+// dependencyCycle returns an error naming the services of the first
+// dependency cycle found, nil when the dependencies form a tree or a DAG
+func dependencyCycle(services []Service) error {
+	byID := map[string]Service{}
+	for _, s := range services {
+		byID[s.ID] = s
+	}
+
+	// Services are white until visited, grey on the current path and black
+	// once all their parents are done
+	const (
+		white = iota
+		grey
+		black
+	)
+	color := map[string]int{}
+	path := []string{}
+	var visit func(id string) error
+	visit = func(id string) error {
+		color[id] = grey
+		path = append(path, id)
+		for _, parent := range byID[id].Parents {
+			switch color[parent] {
+			case grey:
+				names := []string{}
+				for _, id := range path[slices.Index(path, parent):] {
+					names = append(names, byID[id].Name)
+				}
+				names = append(names, byID[parent].Name)
+				return fmt.Errorf("dependency cycle %s", strings.Join(names, " -> "))
+			case white:
+				if _, ok := byID[parent]; !ok {
+					continue
+				}
+				if err := visit(parent); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		color[id] = black
+		return nil
+	}
+
+	for _, s := range services {
+		if color[s.ID] == white {
+			if err := visit(s.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dependencyTree orders services so each one follows its first parent,
+// keeping the stored order among siblings. Services without a parent in the
+// list are roots.
+func dependencyTree(services []Service) []Service {
+	ids := map[string]bool{}
+	for _, s := range services {
+		ids[s.ID] = true
+	}
+	children := map[string][]Service{}
+	roots := []Service{}
+	for _, s := range services {
+		if len(s.Parents) > 0 && ids[s.Parents[0]] && s.Parents[0] != s.ID {
+			children[s.Parents[0]] = append(children[s.Parents[0]], s)
+			continue
+		}
+		roots = append(roots, s)
+	}
+
+	tree := make([]Service, 0, len(services))
+	seen := map[string]bool{}
+	var add func(s Service)
+	add = func(s Service) {
+		if seen[s.ID] {
+			return
+		}
+		seen[s.ID] = true
+		tree = append(tree, s)
+		for _, child := range children[s.ID] {
+			add(child)
+		}
+	}
+	for _, s := range roots {
+		add(s)
+	}
+	// Services stuck in a cycle have no root, keep them in the list anyway
+	for _, s := range services {
+		add(s)
+	}
+	return tree
+}
+
+// dependencyDepth returns how deep a service is in the tree built by
+// dependencyTree, 0 for roots
+func dependencyDepth(s Service, services []Service) int {
+	depth := 0
+	for len(s.Parents) > 0 && depth < len(services) {
+		i := slices.IndexFunc(services, func(p Service) bool { return p.ID == s.Parents[0] })
+		if i < 0 {
+			break
+		}
+		s = services[i]
+		depth++
+	}
+	return depth
+}
+
+// dependents returns the names of the services depending on s
+func dependents(s Service, services []Service) []string {
+	names := []string{}
+	for _, child := range services {
+		if slices.Contains(child.Parents, s.ID) {
+			names = append(names, child.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestDependencyCycle(t *testing.T) {
+	service := func(id string, parents ...string) Service {
+		return Service{ID: id, Name: id, Parents: parents}
+	}
+
+	tests := []struct {
+		name     string
+		services []Service
+		want     string // Blank when there is no cycle
+	}{
+		{
+			name:     "no dependencies",
+			services: []Service{service("a"), service("b")},
+		},
+		{
+			name:     "diamond",
+			services: []Service{service("app", "api", "cache"), service("api", "db"), service("cache", "db"), service("db")},
+		},
+		{
+			name:     "unknown parent",
+			services: []Service{service("a", "gone")},
+		},
+		{
+			name:     "self",
+			services: []Service{service("a", "a")},
+			want:     "dependency cycle a -> a",
+		},
+		{
+			name:     "direct",
+			services: []Service{service("a", "b"), service("b", "a")},
+			want:     "dependency cycle a -> b -> a",
+		},
+		{
+			name:     "indirect",
+			services: []Service{service("a", "b"), service("b", "c"), service("c", "a")},
+			want:     "dependency cycle a -> b -> c -> a",
+		},
+		{
+			name:     "below a root",
+			services: []Service{service("root"), service("a", "root", "b"), service("b", "c"), service("c", "b")},
+			want:     "dependency cycle b -> c -> b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dependencyCycle(tt.services)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("dependencyCycle() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	now := time.Now()
+	failed := Check{Status: StatusOffline, Category: failureConnection, Error: "connection refused", Time: now}
+	parent := func(name string, status Status) serviceCheck {
+		return serviceCheck{Name: name, Check: Check{Status: status, Time: now}}
+	}
+
+	tests := []struct {
+		name    string
+		check   Check
+		parents []serviceCheck
+		want    Status
+		message string
+	}{
+		{
+			name:    "parent offline",
+			check:   failed,
+			parents: []serviceCheck{parent("db", StatusOffline)},
+			want:    StatusUnreachable,
+			message: "db down (connection: connection refused)",
+		},
+		{
+			name:    "parent unreachable",
+			check:   failed,
+			parents: []serviceCheck{parent("db", StatusUnreachable), parent("cache", StatusOnline)},
+			want:    StatusUnreachable,
+			message: "db down (connection: connection refused)",
+		},
+		{
+			name:    "several parents down",
+			check:   Check{Status: StatusOffline, Time: now},
+			parents: []serviceCheck{parent("db", StatusOffline), parent("cache", StatusOffline)},
+			want:    StatusUnreachable,
+			message: "db, cache down",
+		},
+		{
+			name:    "parents up",
+			check:   failed,
+			parents: []serviceCheck{parent("db", StatusOnline), parent("cache", StatusDegraded)},
+			want:    StatusOffline,
+		},
+		{
+			name:    "parent not checked yet",
+			check:   failed,
+			parents: []serviceCheck{{Name: "db"}},
+			want:    StatusOffline,
+		},
+		{
+			name:    "child up",
+			check:   Check{Status: StatusDegraded, Time: now},
+			parents: []serviceCheck{parent("db", StatusOffline)},
+			want:    StatusDegraded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unreachable(tt.check, tt.parents)
+			if got.Status != tt.want {
+				t.Fatalf("status = %s, want %s", got.Status, tt.want)
+			}
+			if tt.want != StatusUnreachable {
+				if got.Category != tt.check.Category || got.Error != tt.check.Error {
+					t.Errorf("check changed to %s: %s", got.Category, got.Error)
+				}
+				return
+			}
+			if got.Category != failureDependency || got.Error != tt.message {
+				t.Errorf("got %s: %q, want %s: %q", got.Category, got.Error, failureDependency, tt.message)
+			}
+		})
+	}
+}
+
+func TestAlerterSuppressesUnreachable(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	a := &Alerter{
+		ctx:      context.Background(),
+		webhooks: []string{server.URL},
+		template: template.Must(template.New("webhook").Parse(`{"event": "{{.Event}}", "service": "{{.Service.Name}}"}`)),
+		client:   server.Client(),
+		last:     map[string]Status{},
+	}
+	db := Service{ID: "db", Name: "db"}
+	app := Service{ID: "app", Name: "app", Parents: []string{"db"}}
+	// Alerts posted so far
+	observe := func(s Service, status Status) []string {
+		a.Observe(Result{Service: s, Check: Check{Status: status, Time: time.Now()}})
+		a.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(bodies)
+	}
+
+	observe(db, StatusOnline)
+	observe(app, StatusOnline)
+
+	// The parent goes down and its child fails with it
+	observe(db, StatusOffline)
+	parents := []serviceCheck{{Name: "db", Check: Check{Status: StatusOffline, Time: time.Now()}}}
+	child := unreachable(Check{Status: StatusOffline, Category: failureConnection, Error: "refused"}, parents)
+	alerts := observe(app, child.Status)
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts during the outage, want 1: %v", len(alerts), alerts)
+	}
+	if !strings.Contains(alerts[0], `"service": "db"`) {
+		t.Errorf("alert is %s, want one for db", alerts[0])
+	}
+
+	// Both recover, the child never alerted so it doesn't alert its recovery
+	observe(db, StatusOnline)
+	alerts = observe(app, StatusOnline)
+	if len(alerts) != 2 {
+		t.Fatalf("got %d alerts after recovery, want 2: %v", len(alerts), alerts)
+	}
+	if !strings.Contains(alerts[1], `"event": "up", "service": "db"`) {
+		t.Errorf("recovery alert is %s, want up for db", alerts[1])
+	}
+}
+
+func TestUptimeSkipsUnreachable(t *testing.T) {
+	t.Chdir(t.TempDir())
+	store := new(Store)
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.conn.Close()
+
+	app := Service{ID: "app", Name: "app"}
+	if err := store.SaveService(app); err != nil {
+		t.Fatal(err)
+	}
+	since := time.Now().Add(-time.Hour)
+	uptime := func() (float64, int) {
+		t.Helper()
+		uptime, checks, err := store.GetUptime(app, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uptime, checks
+	}
+
+	// Only unreachable checks, nothing is known about the service
+	for range 2 {
+		if err := store.SaveHistory(app, Check{Status: StatusUnreachable, Category: failureDependency, Error: "db down"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, checks := uptime(); checks != 0 {
+		t.Errorf("got %d checks, want none while unreachable", checks)
+	}
+
+	for _, status := range []Status{StatusOnline, StatusDegraded, StatusOffline} {
+		if err := store.SaveHistory(app, Check{Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A row written before the state column existed counts by its status
+	if _, err := store.conn.Exec(`INSERT INTO history (service_id, status) VALUES (?, ?);`, app.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, checks := uptime(); checks != 4 || got != 0.75 {
+		t.Errorf("uptime = %v over %d checks, want 0.75 over 4", got, checks)
+	}
+}
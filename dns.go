@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Record types a DNS service can query
+var dnsRecordTypes = []string{"A", "AAAA", "CNAME", "MX", "TXT"}
+
+const defaultRecordType = "A"
+
+// Targets of dns assertions: the values of the answers, how many there are
+// and their TTL
+const (
+	dnsAnswer = "answer"
+	dnsCount  = "count"
+	dnsTTL    = "ttl"
+)
+
+// Operators supported by each dns assertion target
+var dnsOperators = map[string][]string{
+	dnsAnswer: {opContains, opNotContains, opRegex},
+	dnsCount:  {opEqual, opNotEqual, opLess, opLessEqual, opGreater, opGreaterEqual},
+	dnsTTL:    {opEqual, opNotEqual, opLess, opLessEqual, opGreater, opGreaterEqual},
+}
+
+// dnsRecord is an answer to a DNS query, its value rendered as text: an
+// address, a name, "preference host" for MX or the joined TXT strings.
+type dnsRecord struct {
+	Value string
+	TTL   uint32
+}
+
+// getDNSStatus resolves the endpoint of a service against its resolver and
+// checks the answers with its dns assertions.
+func getDNSStatus(ctx context.Context, s Service) Check {
+	check := Check{Time: time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, msDuration(s.Timeout, defaultTimeout))
+	defer cancel()
+
+	recordType := strings.ToUpper(s.RecordType)
+	if recordType == "" {
+		recordType = defaultRecordType
+	}
+	qtype, ok := dns.StringToType[recordType]
+	if !ok || !slices.Contains(dnsRecordTypes, recordType) {
+		return check.fail(failureRequest, fmt.Errorf("unsupported record type %q", s.RecordType))
+	}
+	resolver, err := resolverAddress(s.Resolver)
+	if err != nil {
+		return check.fail(failureRequest, err)
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(s.Endpoint), qtype)
+
+	start := time.Now()
+	client := &dns.Client{Net: "udp"}
+	resp, _, err := client.ExchangeContext(ctx, msg, resolver)
+	// Answers too large for UDP are fetched again over TCP
+	if err == nil && resp.Truncated {
+		client.Net = "tcp"
+		resp, _, err = client.ExchangeContext(ctx, msg, resolver)
+	}
+	check.Latency = time.Since(start)
+	if err != nil {
+		return check.fail(classifyError(err), err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return check.fail(failureDNS, fmt.Errorf("%s %s: %s", recordType, s.Endpoint, dns.RcodeToString[resp.Rcode]))
+	}
+
+	records := dnsRecords(resp.Answer, qtype)
+	assertions := s.sourceAssertions(sourceDNS)
+	// An empty answer is a failure unless the assertions expect one
+	countAsserted := slices.ContainsFunc(assertions, func(a Assertion) bool { return a.Target == dnsCount })
+	if len(records) == 0 && !countAsserted {
+		return check.fail(failureDNS, fmt.Errorf("no %s records for %s", recordType, s.Endpoint))
+	}
+	for _, a := range assertions {
+		if err := a.evalDNS(records); err != nil {
+			return check.fail(failureAnswer, err)
+		}
+	}
+
+	return gradeLatency(s, check)
+}
+
+// dnsRecords keeps the answers of the queried type, as a query for an A
+// record also returns the CNAME records leading to it.
+func dnsRecords(answers []dns.RR, qtype uint16) []dnsRecord {
+	records := []dnsRecord{}
+	for _, rr := range answers {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		var value string
+		switch rr := rr.(type) {
+		case *dns.A:
+			value = rr.A.String()
+		case *dns.AAAA:
+			value = rr.AAAA.String()
+		case *dns.CNAME:
+			value = strings.TrimSuffix(rr.Target, ".")
+		case *dns.MX:
+			value = fmt.Sprintf("%d %s", rr.Preference, strings.TrimSuffix(rr.Mx, "."))
+		case *dns.TXT:
+			value = strings.Join(rr.Txt, "")
+		default:
+			continue
+		}
+		records = append(records, dnsRecord{Value: value, TTL: rr.Header().Ttl})
+	}
+	return records
+}
+
+// evalDNS checks a dns assertion against the records of an answer
+func (a Assertion) evalDNS(records []dnsRecord) error {
+	switch a.Target {
+	case dnsCount:
+		ok, err := compareValues(json.Number(strconv.Itoa(len(records))), a.Operator, a.Value)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%d records, expected %s %s", len(records), a.Operator, a.Value)
+		}
+
+	case dnsTTL:
+		// Every record must be within the bounds
+		for _, r := range records {
+			ok, err := compareValues(json.Number(strconv.FormatUint(uint64(r.TTL), 10)), a.Operator, a.Value)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%s has TTL %d, expected %s %s", r.Value, r.TTL, a.Operator, a.Value)
+			}
+		}
+
+	case dnsAnswer:
+		values := make([]string, 0, len(records))
+		for _, r := range records {
+			values = append(values, r.Value)
+		}
+		// A record matches the full value or, for MX, its host alone
+		matches := func(expected string) bool {
+			expected = strings.TrimSuffix(expected, ".")
+			for _, v := range values {
+				_, host, _ := strings.Cut(v, " ")
+				if strings.EqualFold(v, expected) || strings.EqualFold(host, expected) {
+					return true
+				}
+			}
+			return false
+		}
+		switch a.Operator {
+		case opContains:
+			if !matches(a.Value) {
+				return fmt.Errorf("answer %s doesn't contain %s", strings.Join(values, ", "), a.Value)
+			}
+		case opNotContains:
+			if matches(a.Value) {
+				return fmt.Errorf("answer contains %s", a.Value)
+			}
+		case opRegex:
+			re, err := regexp.Compile(a.Value)
+			if err != nil {
+				return err
+			}
+			if !slices.ContainsFunc(values, re.MatchString) {
+				return fmt.Errorf("answer %s doesn't match %s", strings.Join(values, ", "), a.Value)
+			}
+		}
+
+	default:
+		return fmt.Errorf("invalid dns assertion target %q", a.Target)
+	}
+	return nil
+}
+
+// resolverAddress adds the default port to a resolver. A blank resolver
+// falls back to the first nameserver of the system configuration.
+func resolverAddress(resolver string) (string, error) {
+	resolver = strings.TrimSpace(resolver)
+	if resolver == "" {
+		if runtime.GOOS == "windows" {
+			return "", errors.New("a resolver is required on Windows")
+		}
+		config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil || len(config.Servers) == 0 {
+			return "", fmt.Errorf("no resolver configured and none found in /etc/resolv.conf")
+		}
+		return net.JoinHostPort(config.Servers[0], config.Port), nil
+	}
+	if _, _, err := net.SplitHostPort(resolver); err != nil {
+		return net.JoinHostPort(strings.Trim(resolver, "[]"), "53"), nil
+	}
+	return resolver, nil
+}
+
+// validResolver reports whether resolver is blank, a host or a host:port
+func validResolver(resolver string) bool {
+	if resolver == "" {
+		return true
+	}
+	if _, _, err := net.SplitHostPort(resolver); err != nil {
+		return !strings.ContainsAny(resolver, " /:") || net.ParseIP(strings.Trim(resolver, "[]")) != nil
+	}
+	return validTCPEndpoint(resolver)
+}
+
+// validDomain reports whether name can be queried
+func validDomain(name string) bool {
+	if name == "" || strings.ContainsAny(name, " /:") {
+		return false
+	}
+	_, ok := dns.IsDomainName(name)
+	return ok
+}
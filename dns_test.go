@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startDNSServer serves a few test zones over UDP on a local port and
+// returns its address. Queries for slow.test. are never answered.
+func startDNSServer(t *testing.T) string {
+	t.Helper()
+
+	records := map[string][]string{
+		"example.test.": {
+			"example.test. 300 IN A 192.0.2.1",
+			"example.test. 300 IN A 192.0.2.2",
+		},
+		"alias.test.": {
+			"alias.test. 60 IN CNAME example.test.",
+			"example.test. 300 IN A 192.0.2.1",
+		},
+		"mail.test.": {
+			"mail.test. 3600 IN MX 10 mx1.mail.test.",
+			"mail.test. 3600 IN MX 20 mx2.mail.test.",
+		},
+		"txt.test.": {
+			`txt.test. 120 IN TXT "v=spf1 " "-all"`,
+		},
+		"empty.test.": {},
+	}
+
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", func(w dns.ResponseWriter, req *dns.Msg) {
+		q := req.Question[0]
+		if q.Name == "slow.test." {
+			return
+		}
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		answers, ok := records[q.Name]
+		if !ok {
+			resp.Rcode = dns.RcodeNameError
+		}
+		for _, text := range answers {
+			rr, err := dns.NewRR(text)
+			if err != nil {
+				t.Errorf("invalid test record %q: %v", text, err)
+				continue
+			}
+			if rr.Header().Rrtype == q.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+		w.WriteMsg(resp)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: mux, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+func TestDNSStatus(t *testing.T) {
+	resolver := startDNSServer(t)
+
+	tests := []struct {
+		name       string
+		endpoint   string
+		recordType string
+		assertions []string
+		timeout    string
+		want       Status
+		category   string
+	}{
+		{name: "resolves", endpoint: "example.test", want: StatusOnline},
+		{name: "answer contains", endpoint: "example.test", assertions: []string{"dns answer contains 192.0.2.2"}, want: StatusOnline},
+		{name: "answer missing", endpoint: "example.test", assertions: []string{"dns answer contains 192.0.2.9"}, want: StatusOffline, category: failureAnswer},
+		{name: "answer not contains", endpoint: "example.test", assertions: []string{"dns answer !contains 192.0.2.1"}, want: StatusOffline, category: failureAnswer},
+		{name: "answer regex", endpoint: "example.test", assertions: []string{`dns answer regex ^192\.0\.2\.`}, want: StatusOnline},
+		{name: "cname target", endpoint: "alias.test", recordType: "CNAME", assertions: []string{"dns answer contains example.test."}, want: StatusOnline},
+		{name: "cname skipped for A", endpoint: "alias.test", assertions: []string{"dns count == 1", "dns ttl == 300"}, want: StatusOnline},
+		{name: "mx host", endpoint: "mail.test", recordType: "MX", assertions: []string{"dns answer contains mx2.mail.test"}, want: StatusOnline},
+		{name: "mx preference", endpoint: "mail.test", recordType: "MX", assertions: []string{"dns answer contains 10 mx1.mail.test"}, want: StatusOnline},
+		{name: "txt joined", endpoint: "txt.test", recordType: "TXT", assertions: []string{"dns answer regex ^v=spf1 -all$"}, want: StatusOnline},
+		{name: "count", endpoint: "example.test", assertions: []string{"dns count == 2"}, want: StatusOnline},
+		{name: "count too low", endpoint: "example.test", assertions: []string{"dns count >= 3"}, want: StatusOffline, category: failureAnswer},
+		{name: "ttl", endpoint: "example.test", assertions: []string{"dns ttl >= 60", "dns ttl <= 3600"}, want: StatusOnline},
+		{name: "ttl too long", endpoint: "mail.test", recordType: "MX", assertions: []string{"dns ttl < 3600"}, want: StatusOffline, category: failureAnswer},
+		{name: "nxdomain", endpoint: "missing.test", want: StatusOffline, category: failureDNS},
+		{name: "nxdomain with count", endpoint: "missing.test", assertions: []string{"dns count == 0"}, want: StatusOffline, category: failureDNS},
+		{name: "empty answer", endpoint: "empty.test", want: StatusOffline, category: failureDNS},
+		{name: "wrong type", endpoint: "example.test", recordType: "AAAA", want: StatusOffline, category: failureDNS},
+		{name: "empty answer expected", endpoint: "empty.test", assertions: []string{"dns count == 0"}, want: StatusOnline},
+		{name: "timeout", endpoint: "slow.test", timeout: "200", want: StatusOffline, category: failureTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Service{
+				Type:       typeDNS,
+				Endpoint:   tt.endpoint,
+				Resolver:   resolver,
+				RecordType: tt.recordType,
+				Timeout:    tt.timeout,
+			}
+			for _, line := range tt.assertions {
+				a, err := parseAssertion(line)
+				if err != nil {
+					t.Fatalf("parseAssertion(%q): %v", line, err)
+				}
+				s.Assertions = append(s.Assertions, a)
+			}
+
+			check := getDNSStatus(context.Background(), s)
+			if check.Status != tt.want || check.Category != tt.category {
+				t.Errorf("got %s (%s: %s), want %s (%s)", check.Status, check.Category, check.Error, tt.want, tt.category)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// getGRPCStatus calls grpc.health.v1.Health/Check on a host:port endpoint.
+// The service is up only when the server reports it SERVING.
+func getGRPCStatus(ctx context.Context, s Service) Check {
+	check := Check{Time: time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, msDuration(s.Timeout, defaultTimeout))
+	defer cancel()
+
+	creds := insecure.NewCredentials()
+	if s.GRPCTLS == "true" {
+		creds = credentials.NewTLS(&tls.Config{InsecureSkipVerify: s.InsecureSkipVerify == "true"})
+	}
+	conn, err := grpc.NewClient(s.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return check.fail(failureRequest, err)
+	}
+	defer conn.Close()
+
+	// Custom headers are sent as request metadata
+	for _, h := range s.Headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, h.Name, h.Value)
+	}
+
+	var p peer.Peer
+	start := time.Now()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: s.GRPCService}, grpc.Peer(&p))
+	check.Latency = time.Since(start)
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		check.CertExpiry = chainExpiry(info.State.PeerCertificates)
+	}
+	if err != nil {
+		return check.fail(grpcFailure(err), err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return check.fail(failureGRPC, fmt.Errorf("health status is %s", resp.GetStatus()))
+	}
+
+	return gradeCertificate(s, gradeLatency(s, check))
+}
+
+// grpcFailure maps the error of a health check call to its failure category
+func grpcFailure(err error) string {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded:
+		return failureTimeout
+	case codes.Unavailable:
+		return failureConnection
+	default:
+		return failureGRPC
+	}
+}
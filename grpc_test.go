@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestGRPCStatus(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The requested service and the metadata of the last call
+	var mu sync.Mutex
+	var service string
+	var md metadata.MD
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		mu.Lock()
+		service = req.(*healthpb.HealthCheckRequest).GetService()
+		md, _ = metadata.FromIncomingContext(ctx)
+		mu.Unlock()
+		return handler(ctx, req)
+	}))
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("api", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("worker", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		endpoint string
+		service  string
+		want     Status
+		category string
+	}{
+		{name: "server", endpoint: ln.Addr().String(), want: StatusOnline},
+		{name: "serving", endpoint: ln.Addr().String(), service: "api", want: StatusOnline},
+		{name: "not serving", endpoint: ln.Addr().String(), service: "worker", want: StatusOffline, category: failureGRPC},
+		{name: "unknown service", endpoint: ln.Addr().String(), service: "billing", want: StatusOffline, category: failureGRPC},
+		{name: "refused", endpoint: closed.Addr().String(), want: StatusOffline, category: failureConnection},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Service{
+				Type:               typeGRPC,
+				Endpoint:           tt.endpoint,
+				GRPCService:        tt.service,
+				GRPCTLS:            "false",
+				InsecureSkipVerify: "false",
+				Timeout:            "2000",
+				Headers:            []Header{{Name: "X-Probe", Value: "goardian"}},
+			}
+			check := getGRPCStatus(context.Background(), s)
+			if check.Status != tt.want || check.Category != tt.category {
+				t.Fatalf("got %s (%s: %s), want %s (%s)", check.Status, check.Category, check.Error, tt.want, tt.category)
+			}
+			if tt.category == failureConnection {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if service != tt.service {
+				t.Errorf("server got service %q, want %q", service, tt.service)
+			}
+			if got := md.Get("x-probe"); len(got) != 1 || got[0] != "goardian" {
+				t.Errorf("server got x-probe metadata %v, want goardian", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	defaultPingCount = 3
+	maxPingCount     = 100
+	// Time between two echo requests of a burst
+	pingInterval = 200 * time.Millisecond
+)
+
+// IANA protocol numbers, needed to parse replies
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+// getICMPStatus sends a burst of echo requests to the endpoint host. The
+// latency is the average round trip time, any lost packet marks the service
+// degraded and losing all of them marks it offline.
+func getICMPStatus(ctx context.Context, s Service) Check {
+	check := Check{Time: time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, msDuration(s.Timeout, defaultTimeout))
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, s.Endpoint)
+	if err != nil {
+		return check.fail(classifyError(err), err)
+	}
+	ip := addrs[0].IP
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ip = addr.IP
+			break
+		}
+	}
+
+	conn, raw, err := listenICMP(ip.To4() != nil)
+	if err != nil {
+		return check.fail(failureConnection, fmt.Errorf("unable to open ICMP socket: %w", err))
+	}
+	defer conn.Close()
+
+	// Unprivileged sockets are addressed like UDP, raw ones like IP
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	if raw {
+		dst = &net.IPAddr{IP: ip}
+	}
+
+	count := pingCount(s.PingCount)
+	received := 0
+	var total time.Duration
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pingInterval):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Whatever the remaining pings, each one gets a fair share of the timeout
+		deadline, _ := ctx.Deadline()
+		wait := time.Until(deadline) / time.Duration(count-seq+1)
+		rtt, err := ping(conn, dst, seq, time.Now().Add(wait))
+		if err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				return check.fail(classifyError(err), err)
+			}
+			continue
+		}
+		received++
+		total += rtt
+	}
+	// Pings cut short by the timeout count as lost
+	check.PacketsSent = count
+	check.PacketsLost = count - received
+
+	if received == 0 {
+		return check.fail(failurePacketLoss, fmt.Errorf("all %d packets lost", count))
+	}
+	check.Latency = total / time.Duration(received)
+
+	check = gradeLatency(s, check)
+	if check.PacketsLost > 0 && check.Status == StatusOnline {
+		check.Status = StatusDegraded
+		check.Category = failurePacketLoss
+		check.Error = fmt.Sprintf("%d of %d packets lost", check.PacketsLost, count)
+	}
+	return check
+}
+
+// listenICMP opens an unprivileged datagram ICMP socket, falling back to a
+// raw socket when they aren't allowed, e.g. outside the ping group range.
+func listenICMP(v4 bool) (*icmp.PacketConn, bool, error) {
+	network, rawNetwork, address := "udp4", "ip4:icmp", "0.0.0.0"
+	if !v4 {
+		network, rawNetwork, address = "udp6", "ip6:ipv6-icmp", "::"
+	}
+	conn, err := icmp.ListenPacket(network, address)
+	if err == nil {
+		return conn, false, nil
+	}
+	conn, rawErr := icmp.ListenPacket(rawNetwork, address)
+	if rawErr != nil {
+		return nil, false, err
+	}
+	return conn, true, nil
+}
+
+// ping sends an echo request and waits for its reply until deadline. Replies
+// are matched by sequence and a random payload, as unprivileged sockets pick
+// their own identifier and raw sockets see every reply of the host.
+func ping(conn *icmp.PacketConn, dst net.Addr, seq int, deadline time.Time) (time.Duration, error) {
+	v4 := conn.IPv4PacketConn() != nil
+	var requestType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	protocol := protocolICMP
+	if !v4 {
+		requestType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		protocol = protocolIPv6ICMP
+	}
+
+	token := make([]byte, 16)
+	rand.Read(token)
+	msg := icmp.Message{
+		Type: requestType,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq, Data: token},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if _, err := conn.WriteTo(b, dst); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		reply, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		echo, ok := reply.Body.(*icmp.Echo)
+		if ok && echo.Seq == seq && bytes.Equal(echo.Data, token) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// pingCount returns the number of echo requests sent by a check
+func pingCount(value string) int {
+	count, err := strconv.Atoi(value)
+	if err != nil || count <= 0 {
+		return defaultPingCount
+	}
+	return min(count, maxPingCount)
+}
+
+// validHost reports whether value is a host name or an IP address
+func validHost(value string) bool {
+	return net.ParseIP(value) != nil || validDomain(value)
+}
+
+// validPingCount reports whether value is blank or a valid burst size
+func validPingCount(value string) bool {
+	if value == "" {
+		return true
+	}
+	count, err := strconv.Atoi(value)
+	return err == nil && count > 0 && count <= maxPingCount
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a compiled JSONPath expression. It supports the usual subset:
+//
+//	$.store.book       child by name, also $['store']['book']
+//	$.book[0]          index, negative indexes count from the end
+//	$.book[1:3]        slice
+//	$.book[*], $.*     every element or member
+//	$..price           recursive descent
+//	$.book[?(@.price < 10 && @.tags)]  filter, with the assertion operators
+//
+// Paths without a leading $ are relative to the root, so the legacy
+// "status.code" dot notation keeps working.
+type jsonPath []pathStep
+
+type stepKind int
+
+const (
+	stepChild stepKind = iota
+	stepIndex
+	stepSlice
+	stepWildcard
+	stepFilter
+)
+
+type pathStep struct {
+	kind      stepKind
+	recursive bool // Applies to the node and all of its descendants
+	name      string
+	index     int
+	start     *int
+	end       *int
+	filter    filterExpr
+}
+
+// filterExpr is a filter in disjunctive form: any group of terms where all
+// the terms hold
+type filterExpr [][]filterTerm
+
+// filterTerm compares the nodes selected by a path relative to @ with a
+// value, or tests that the path exists when op is blank.
+type filterTerm struct {
+	path  jsonPath
+	op    string
+	value string
+}
+
+func compileJSONPath(expr string) (jsonPath, error) {
+	expr = strings.TrimSpace(expr)
+	switch {
+	case expr == "":
+		return nil, fmt.Errorf("empty JSONPath")
+	case strings.HasPrefix(expr, "$"), strings.HasPrefix(expr, "@"):
+		expr = expr[1:]
+	case strings.HasPrefix(expr, "["):
+	default:
+		expr = "." + expr
+	}
+
+	path := jsonPath{}
+	for i := 0; i < len(expr); {
+		recursive := false
+		switch {
+		case strings.HasPrefix(expr[i:], ".."):
+			recursive = true
+			i += 2
+		case expr[i] == '.':
+			i++
+		case expr[i] == '[':
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d in JSONPath", expr[i], i)
+		}
+
+		if i < len(expr) && expr[i] == '[' {
+			end := closingBracket(expr, i)
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in JSONPath")
+			}
+			step, err := compileBracket(expr[i+1 : end])
+			if err != nil {
+				return nil, err
+			}
+			step.recursive = recursive
+			path = append(path, step)
+			i = end + 1
+			continue
+		}
+
+		// Dot notation name, up to the next . or [
+		j := i
+		for j < len(expr) && expr[j] != '.' && expr[j] != '[' {
+			j++
+		}
+		name := expr[i:j]
+		if name == "" {
+			return nil, fmt.Errorf("missing name at %d in JSONPath", i)
+		}
+		step := pathStep{kind: stepChild, name: name, recursive: recursive}
+		if name == "*" {
+			step.kind = stepWildcard
+		}
+		path = append(path, step)
+		i = j
+	}
+	return path, nil
+}
+
+// closingBracket finds the ] matching the [ at open, skipping quoted text
+// and nested brackets
+func closingBracket(s string, open int) int {
+	depth := 0
+	var quote byte
+	for i := open; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func compileBracket(content string) (pathStep, error) {
+	content = strings.TrimSpace(content)
+	switch {
+	case content == "*":
+		return pathStep{kind: stepWildcard}, nil
+	case strings.HasPrefix(content, "?(") && strings.HasSuffix(content, ")"):
+		filter, err := compileFilter(content[2 : len(content)-1])
+		if err != nil {
+			return pathStep{}, err
+		}
+		return pathStep{kind: stepFilter, filter: filter}, nil
+	case strings.HasPrefix(content, "'") || strings.HasPrefix(content, "\""):
+		name, err := unquote(content)
+		if err != nil {
+			return pathStep{}, fmt.Errorf("invalid name %s in JSONPath", content)
+		}
+		return pathStep{kind: stepChild, name: name}, nil
+	case strings.Contains(content, ":"):
+		from, to, _ := strings.Cut(content, ":")
+		step := pathStep{kind: stepSlice}
+		for _, bound := range []struct {
+			text string
+			dst  **int
+		}{{from, &step.start}, {to, &step.end}} {
+			text := strings.TrimSpace(bound.text)
+			if text == "" {
+				continue
+			}
+			n, err := strconv.Atoi(text)
+			if err != nil {
+				return pathStep{}, fmt.Errorf("invalid slice [%s] in JSONPath", content)
+			}
+			*bound.dst = &n
+		}
+		return step, nil
+	default:
+		n, err := strconv.Atoi(content)
+		if err != nil {
+			return pathStep{}, fmt.Errorf("invalid index [%s] in JSONPath", content)
+		}
+		return pathStep{kind: stepIndex, index: n}, nil
+	}
+}
+
+func compileFilter(expr string) (filterExpr, error) {
+	filter := filterExpr{}
+	for _, group := range splitOutsideQuotes(expr, "||") {
+		terms := []filterTerm{}
+		for _, text := range splitOutsideQuotes(group, "&&") {
+			term, err := compileFilterTerm(strings.TrimSpace(text))
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, term)
+		}
+		filter = append(filter, terms)
+	}
+	return filter, nil
+}
+
+func compileFilterTerm(text string) (filterTerm, error) {
+	if !strings.HasPrefix(text, "@") {
+		return filterTerm{}, fmt.Errorf("filter %q must start with @", text)
+	}
+
+	// The path ends at the first space or operator character outside brackets
+	end := len(text)
+	depth := 0
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		if c == '[' {
+			depth++
+		} else if c == ']' {
+			depth--
+		} else if depth == 0 && strings.ContainsRune(" =!<>~", rune(c)) {
+			end = i
+			break
+		}
+	}
+
+	path, err := compileJSONPath(text[:end])
+	if err != nil {
+		return filterTerm{}, err
+	}
+	term := filterTerm{path: path}
+
+	rest := strings.TrimSpace(text[end:])
+	if rest == "" {
+		return term, nil
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if strings.HasPrefix(rest, op) {
+			term.op = op
+			if op == "=~" {
+				term.op = opRegex
+			}
+			term.value = strings.TrimSpace(rest[len(op):])
+			// Quoted literals compare as strings
+			if unquoted, err := unquote(term.value); err == nil {
+				term.value = strconv.Quote(unquoted)
+			}
+			return term, nil
+		}
+	}
+	return filterTerm{}, fmt.Errorf("invalid filter %q", text)
+}
+
+// splitOutsideQuotes splits s around sep, ignoring separators in quotes
+func splitOutsideQuotes(s, sep string) []string {
+	parts := []string{}
+	var quote byte
+	last := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[last:i])
+			i += len(sep) - 1
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// unquote reads a single or double quoted string
+func unquote(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		s = `"` + strings.ReplaceAll(strings.ReplaceAll(s[1:len(s)-1], `\'`, `'`), `"`, `\"`) + `"`
+	}
+	return strconv.Unquote(s)
+}
+
+// eval returns every node the path selects in a decoded JSON document
+func (p jsonPath) eval(root any) []any {
+	nodes := []any{root}
+	for _, step := range p {
+		next := []any{}
+		for _, n := range nodes {
+			targets := []any{n}
+			if step.recursive {
+				targets = descendants(n, targets)
+			}
+			for _, t := range targets {
+				next = append(next, step.apply(t)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+func (step pathStep) apply(node any) []any {
+	switch step.kind {
+	case stepChild:
+		if m, ok := node.(map[string]any); ok {
+			if v, ok := m[step.name]; ok {
+				return []any{v}
+			}
+		}
+	case stepIndex:
+		if a, ok := node.([]any); ok {
+			i := step.index
+			if i < 0 {
+				i += len(a)
+			}
+			if i >= 0 && i < len(a) {
+				return []any{a[i]}
+			}
+		}
+	case stepSlice:
+		if a, ok := node.([]any); ok {
+			start, end := 0, len(a)
+			if step.start != nil {
+				start = clampIndex(*step.start, len(a))
+			}
+			if step.end != nil {
+				end = clampIndex(*step.end, len(a))
+			}
+			if start < end {
+				return slices.Clone(a[start:end])
+			}
+		}
+	case stepWildcard:
+		return children(node)
+	case stepFilter:
+		matches := []any{}
+		for _, child := range children(node) {
+			if step.filter.match(child) {
+				matches = append(matches, child)
+			}
+		}
+		return matches
+	}
+	return nil
+}
+
+func (f filterExpr) match(node any) bool {
+	for _, group := range f {
+		all := true
+		for _, term := range group {
+			if !term.match(node) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+func (t filterTerm) match(node any) bool {
+	values := t.path.eval(node)
+	if t.op == "" {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if ok, err := compareValues(v, t.op, t.value); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// children lists the elements of an array or the members of an object, in
+// key order so results are stable
+func children(node any) []any {
+	switch n := node.(type) {
+	case []any:
+		return slices.Clone(n)
+	case map[string]any:
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		values := make([]any, 0, len(n))
+		for _, k := range keys {
+			values = append(values, n[k])
+		}
+		return values
+	}
+	return nil
+}
+
+// descendants appends every node below node to dst, depth first
+func descendants(node any, dst []any) []any {
+	for _, child := range children(node) {
+		dst = append(dst, child)
+		dst = descendants(child, dst)
+	}
+	return dst
+}
+
+func clampIndex(i, length int) int {
+	if i < 0 {
+		i += length
+	}
+	return max(0, min(i, length))
+}
@@ -0,0 +1,104 @@
+package main
+
+import "testing"
+
+const storeDoc = `{
+	"status": {"code": 200, "message": "ok"},
+	"store": {
+		"book": [
+			{"title": "Sayings", "price": 8.95, "tags": ["quotes"]},
+			{"title": "Sword", "price": 12.99},
+			{"title": "Moby Dick", "price": 8.99, "isbn": "0-553-21311-3"},
+			{"title": "The Lord", "price": 22.99, "isbn": "0-395-19395-8"}
+		],
+		"bicycle": {"color": "red", "price": 19.95}
+	},
+	"key with space": true
+}`
+
+func TestJSONPathEval(t *testing.T) {
+	doc, err := decodeJSON([]byte(storeDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string // Selected nodes encoded as a JSON array
+	}{
+		// Legacy dot paths are relative to the root
+		{"status.code", `[200]`},
+		{"status", `[{"code":200,"message":"ok"}]`},
+		{"$.status.message", `["ok"]`},
+		{"$['store']['bicycle'].color", `["red"]`},
+		{`$["key with space"]`, `[true]`},
+		{"$.missing", `[]`},
+		{"status.code.deeper", `[]`},
+
+		// Indexes
+		{"$.store.book[0].title", `["Sayings"]`},
+		{"store.book[2].isbn", `["0-553-21311-3"]`},
+		{"$.store.book[-1].title", `["The Lord"]`},
+		{"$.store.book[-4].title", `["Sayings"]`},
+		{"$.store.book[4]", `[]`},
+		{"$.store.book[-5]", `[]`},
+		{"$.status[0]", `[]`},
+
+		// Slices
+		{"$.store.book[1:3].title", `["Sword","Moby Dick"]`},
+		{"$.store.book[:2].title", `["Sayings","Sword"]`},
+		{"$.store.book[2:].title", `["Moby Dick","The Lord"]`},
+		{"$.store.book[-2:].title", `["Moby Dick","The Lord"]`},
+		{"$.store.book[3:1]", `[]`},
+		{"$.store.book[0:100].price", `[8.95,12.99,8.99,22.99]`},
+
+		// Wildcards
+		{"$.store.book[*].price", `[8.95,12.99,8.99,22.99]`},
+		{"$.status.*", `[200,"ok"]`},
+
+		// Filters
+		{"$.store.book[?(@.price < 10)].title", `["Sayings","Moby Dick"]`},
+		{"$.store.book[?(@.isbn)].title", `["Moby Dick","The Lord"]`},
+		{"$.store.book[?(@.price > 10 && @.isbn)].title", `["The Lord"]`},
+		{"$.store.book[?(@.price > 20 || @.tags)].title", `["Sayings","The Lord"]`},
+		{"$.store.book[?(@.title == 'Sword')].price", `[12.99]`},
+		{`$.store.book[?(@.title == "Moby Dick")].price`, `[8.99]`},
+		{"$.store.book[?(@.title =~ ^S)].title", `["Sayings","Sword"]`},
+		{"$.store.book[?(@.title != 'Sword' && @.price <= 8.99)].title", `["Sayings","Moby Dick"]`},
+
+		// Recursive descent
+		{"$..price", `[19.95,8.95,12.99,8.99,22.99]`},
+		{"$..book[0].title", `["Sayings"]`},
+		{"$..isbn", `["0-553-21311-3","0-395-19395-8"]`},
+		{"$..[?(@.price > 20)].title", `["The Lord"]`},
+		{"$..nothing", `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path, err := compileJSONPath(tt.path)
+			if err != nil {
+				t.Fatalf("compileJSONPath(%q): %v", tt.path, err)
+			}
+			if got := textOf(path.eval(doc)); got != tt.want {
+				t.Errorf("eval(%q) = %s, want %s", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileJSONPathErrors(t *testing.T) {
+	for _, path := range []string{
+		"",
+		"$.book[",
+		"$.book[abc]",
+		"$.book[1:x]",
+		"$.book[?(price < 10)]",
+		"$.book[?(@.price ~ 10)]",
+		"$.book.",
+		"$x",
+	} {
+		if _, err := compileJSONPath(path); err == nil {
+			t.Errorf("compileJSONPath(%q) succeeded, want an error", path)
+		}
+	}
+}
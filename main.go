@@ -2,21 +2,135 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"os"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 func main() {
+	configPath := flag.String("config", "", "YAML or JSON file describing the services, synced into the store on startup")
+	headless := flag.Bool("headless", false, "check services without the TUI, logging results until interrupted")
+	workers := flag.Int("workers", defaultWorkers, "maximum number of checks running at the same time")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics at /metrics on this address (e.g. :9090)")
+	var webhooks stringList
+	flag.Var(&webhooks, "webhook", "POST an alert to this URL when a service goes down or comes back up (repeatable)")
+	webhookTemplate := flag.String("webhook-template", "", "text/template file rendering the JSON alert payload")
+	webhookRetries := flag.Int("webhook-retries", defaultWebhookRetries, "retries of a failed webhook delivery")
+	flag.Parse()
+
 	store := new(Store)
 	if err := store.Init(); err != nil {
 		log.Fatalf("unable to init store: %v", err)
 	}
 
-	m := NewModel(store)
+	if *configPath != "" {
+		if err := syncConfig(store, *configPath); err != nil {
+			log.Fatalf("unable to sync config: %v", err)
+		}
+	}
 
-	p := tea.NewProgram(m)
-	if _, err := p.Run(); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scheduler := NewScheduler(store, *workers)
+
+	if *metricsAddr != "" {
+		metrics := NewMetrics()
+		scheduler.Subscribe(metrics.Observe)
+		scheduler.SubscribeRemoved(metrics.Forget)
+		if err := metrics.Listen(ctx, *metricsAddr); err != nil {
+			log.Fatalf("unable to serve metrics: %v", err)
+		}
+	}
+
+	if len(webhooks) > 0 {
+		alerter, err := NewAlerter(ctx, store, webhooks, *webhookTemplate, *webhookRetries)
+		if err != nil {
+			log.Fatalf("unable to set up alerts: %v", err)
+		}
+		scheduler.Subscribe(alerter.Observe)
+		// Pending deliveries are cancelled rather than holding up the exit
+		defer func() {
+			cancel()
+			alerter.Wait()
+		}()
+	}
+
+	var err error
+	if *headless {
+		err = runHeadless(ctx, store, scheduler)
+	} else {
+		err = runTUI(ctx, store, scheduler)
+	}
+	if err != nil {
 		log.Fatalf("unable to run goardian: %v", err)
 	}
 }
+
+// syncConfig loads the config file into the store and reports what changed
+func syncConfig(store *Store, path string) error {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	report, err := store.SyncConfig(config)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range report.Added {
+		log.Printf("config: added service %q", name)
+	}
+	for _, name := range report.Updated {
+		log.Printf("config: updated service %q", name)
+	}
+	for _, name := range report.Removed {
+		log.Printf("config: removed service %q", name)
+	}
+	log.Printf("config %s synced: %s", path, report)
+	return nil
+}
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Log of the TUI, which owns the terminal while it runs
+const tuiLogPath = "./goardian.log"
+
+func runTUI(ctx context.Context, store *Store, scheduler *Scheduler) error {
+	m := NewModel(store, scheduler)
+
+	f, err := tea.LogToFile(tuiLogPath, "")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer log.SetOutput(os.Stderr)
+
+	p := tea.NewProgram(m)
+	// Results reach the TUI as messages, the program drops them once it quits
+	scheduler.Subscribe(func(r Result) {
+		p.Send(resultMsg(r))
+	})
+	if err := scheduler.Start(ctx); err != nil {
+		return err
+	}
+	defer scheduler.Stop()
+
+	_, err = p.Run()
+	return err
+}
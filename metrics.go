@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics exports check results in the Prometheus format. Every series is
+// labeled by service ID and name.
+type Metrics struct {
+	registry   *prometheus.Registry
+	up         *prometheus.GaugeVec
+	duration   *prometheus.HistogramVec
+	statusCode *prometheus.GaugeVec
+	checks     *prometheus.CounterVec
+
+	mu        sync.Mutex
+	names     map[string]string // Last seen name of each service ID
+	forgotten map[string]bool   // Deleted services, IDs are never reused
+}
+
+func NewMetrics() *Metrics {
+	labels := []string{"service_id", "service_name"}
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "goardian_service_up",
+			Help: "Whether the last check of the service succeeded (1) or failed (0).",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "goardian_probe_duration_seconds",
+			Help:    "Duration of the checks of the service.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, labels),
+		statusCode: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "goardian_last_status_code",
+			Help: "HTTP status code of the last check of the service, 0 when there was no response.",
+		}, labels),
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goardian_checks_total",
+			Help: "Number of checks of the service by resulting status.",
+		}, append(labels, "status")),
+		names:     map[string]string{},
+		forgotten: map[string]bool{},
+	}
+	m.registry.MustRegister(m.up, m.duration, m.statusCode, m.checks)
+	return m
+}
+
+// Observe records a check result, it is meant to be subscribed to the
+// scheduler.
+func (m *Metrics) Observe(r Result) {
+	id, name := r.Service.ID, r.Service.Name
+
+	// The series are updated under the lock so a check still in flight when
+	// its service is deleted can't bring them back after Forget
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.forgotten[id] {
+		return
+	}
+
+	// Drop the series of a renamed service so they don't linger
+	if old, ok := m.names[id]; ok && old != name {
+		m.forget(id)
+	}
+	m.names[id] = name
+
+	up := 0.0
+	if r.Status.Up() {
+		up = 1
+	}
+	m.up.WithLabelValues(id, name).Set(up)
+	m.duration.WithLabelValues(id, name).Observe(r.Latency.Seconds())
+	m.statusCode.WithLabelValues(id, name).Set(float64(r.StatusCode))
+	m.checks.WithLabelValues(id, name, string(r.Status)).Inc()
+}
+
+// Forget drops the series of a service that is no longer checked, it is
+// meant to be subscribed to the removals of the scheduler.
+func (m *Metrics) Forget(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forget(id)
+	delete(m.names, id)
+	m.forgotten[id] = true
+}
+
+func (m *Metrics) forget(id string) {
+	labels := prometheus.Labels{"service_id": id}
+	m.up.DeletePartialMatch(labels)
+	m.duration.DeletePartialMatch(labels)
+	m.statusCode.DeletePartialMatch(labels)
+	m.checks.DeletePartialMatch(labels)
+}
+
+// Listen starts serving the metrics at /metrics on addr in the background
+// until ctx is cancelled. It only fails when addr can't be bound.
+func (m *Metrics) Listen(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("metrics server stopped: %v", err)
+		}
+	}()
+	return nil
+}
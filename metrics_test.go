@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// seriesOf gathers the registry and returns the families with a series of
+// the service and the service names these series are labeled with
+func seriesOf(t *testing.T, m *Metrics, id string) (families, names []string) {
+	t.Helper()
+	gathered, err := m.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range gathered {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["service_id"] != id {
+				continue
+			}
+			if !slices.Contains(families, family.GetName()) {
+				families = append(families, family.GetName())
+			}
+			if !slices.Contains(names, labels["service_name"]) {
+				names = append(names, labels["service_name"])
+			}
+		}
+	}
+	return families, names
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	result := func(id, name string, status Status) Result {
+		return Result{Service: Service{ID: id, Name: name}, Check: Check{Status: status, StatusCode: 200, Latency: 20 * time.Millisecond}}
+	}
+	all := []string{"goardian_checks_total", "goardian_last_status_code", "goardian_probe_duration_seconds", "goardian_service_up"}
+
+	m.Observe(result("a", "api", StatusOnline))
+	m.Observe(result("b", "db", StatusOffline))
+	for _, id := range []string{"a", "b"} {
+		families, _ := seriesOf(t, m, id)
+		slices.Sort(families)
+		if !slices.Equal(families, all) {
+			t.Fatalf("service %s has series %v, want %v", id, families, all)
+		}
+	}
+
+	// A renamed service keeps only the series with its new name
+	m.Observe(result("a", "gateway", StatusOnline))
+	if _, names := seriesOf(t, m, "a"); !slices.Equal(names, []string{"gateway"}) {
+		t.Errorf("renamed service has series named %v, want gateway", names)
+	}
+
+	// A check that finishes after its service is deleted doesn't bring the
+	// series back
+	m.Forget("b")
+	if families, _ := seriesOf(t, m, "b"); len(families) != 0 {
+		t.Errorf("forgotten service still has series %v", families)
+	}
+	m.Observe(result("b", "db", StatusOnline))
+	if families, _ := seriesOf(t, m, "b"); len(families) != 0 {
+		t.Errorf("late result recreated series %v", families)
+	}
+	if families, _ := seriesOf(t, m, "a"); len(families) != len(all) {
+		t.Errorf("other service has series %v, want %v", families, all)
+	}
+}
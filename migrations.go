@@ -0,0 +1,349 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+// migration upgrades the schema by one version. Every migration runs in its
+// own transaction along with the bump of the schema version, so a failure
+// leaves the database at the previous version.
+type migration func(tx *sql.Tx) error
+
+// migrations are applied in order, the schema version is the number of
+// migrations applied. Only ever append to this list.
+var migrations = []migration{
+	// 1: services and history
+	func(tx *sql.Tx) error {
+		return execAll(tx,
+			`CREATE TABLE IF NOT EXISTS services (
+				id text not null primary key,
+				name text not null,
+				method text not null,
+				endpoint text not null,
+				payload text not null,
+				request_delay text not null,
+				json_property text null,
+				expected_value text null,
+				preferred_status text null,
+				insecure_skip_verify text null
+			);`,
+			`CREATE TABLE IF NOT EXISTS history (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				service_id TEXT NOT NULL,
+				status BOOLEAN NOT NULL,
+				timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY(service_id) REFERENCES services(id)
+			);`,
+		)
+	},
+	// 2: custom headers and authentication
+	func(tx *sql.Tx) error {
+		err := execAll(tx, `CREATE TABLE IF NOT EXISTS headers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value TEXT NOT NULL,
+			FOREIGN KEY(service_id) REFERENCES services(id)
+		);`)
+		if err != nil {
+			return err
+		}
+		return addColumns(tx, "services",
+			"auth_type text null",
+			"auth_username text null",
+			"auth_secret text null",
+			"auth_header text null",
+		)
+	},
+	// 3: timeout, latency thresholds and three-state history
+	func(tx *sql.Tx) error {
+		if err := addColumns(tx, "services",
+			"timeout text null",
+			"degraded_threshold text null",
+			"down_threshold text null",
+		); err != nil {
+			return err
+		}
+		return addColumns(tx, "history", "state TEXT NULL")
+	},
+	// 4: check details in history
+	func(tx *sql.Tx) error {
+		return addColumns(tx, "history",
+			"latency_ms INTEGER NULL",
+			"status_code INTEGER NULL",
+			"error_category TEXT NULL",
+			"error_message TEXT NULL",
+		)
+	},
+	// 5: webhook delivery failures
+	func(tx *sql.Tx) error {
+		return execAll(tx, `CREATE TABLE IF NOT EXISTS alert_failures (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id TEXT NOT NULL,
+			webhook TEXT NOT NULL,
+			event TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			error_message TEXT NOT NULL,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(service_id) REFERENCES services(id)
+		);`)
+	},
+	// 6: services defined by the config file
+	func(tx *sql.Tx) error {
+		return addColumns(tx, "services", "managed text null")
+	},
+	// 7: response assertions
+	func(tx *sql.Tx) error {
+		return execAll(tx, `CREATE TABLE IF NOT EXISTS assertions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id TEXT NOT NULL,
+			source TEXT NOT NULL,
+			target TEXT NOT NULL,
+			operator TEXT NOT NULL,
+			value TEXT NOT NULL,
+			FOREIGN KEY(service_id) REFERENCES services(id)
+		);`)
+	},
+	// 8: TLS certificate expiry
+	func(tx *sql.Tx) error {
+		if err := addColumns(tx, "services",
+			"cert_warning_days text null",
+			"cert_critical_days text null",
+		); err != nil {
+			return err
+		}
+		return addColumns(tx, "history", "cert_expires_at DATETIME NULL")
+	},
+	// 9: service types and TCP banners
+	func(tx *sql.Tx) error {
+		return addColumns(tx, "services",
+			"service_type text null",
+			"banner text null",
+		)
+	},
+	// 10: DNS services
+	func(tx *sql.Tx) error {
+		return addColumns(tx, "services",
+			"resolver text null",
+			"record_type text null",
+		)
+	},
+	// 11: ICMP services and packet loss in history
+	func(tx *sql.Tx) error {
+		if err := addColumns(tx, "services", "ping_count text null"); err != nil {
+			return err
+		}
+		return addColumns(tx, "history",
+			"packets_sent INTEGER NULL",
+			"packets_lost INTEGER NULL",
+		)
+	},
+	// 12: gRPC services
+	func(tx *sql.Tx) error {
+		return addColumns(tx, "services",
+			"grpc_service text null",
+			"grpc_tls text null",
+		)
+	},
+	// 13: WebSocket reply latency in history
+	func(tx *sql.Tx) error {
+		return addColumns(tx, "history", "reply_latency_ms INTEGER NULL")
+	},
+	// 14: multistep services, their steps keep their headers and assertions
+	// in the service tables
+	func(tx *sql.Tx) error {
+		err := execAll(tx,
+			`CREATE TABLE IF NOT EXISTS steps (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				service_id TEXT NOT NULL,
+				position INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				method TEXT NOT NULL,
+				endpoint TEXT NOT NULL,
+				payload TEXT NOT NULL,
+				preferred_status TEXT NOT NULL,
+				FOREIGN KEY(service_id) REFERENCES services(id)
+			);`,
+			`CREATE TABLE IF NOT EXISTS extractions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				service_id TEXT NOT NULL,
+				step INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				source TEXT NOT NULL,
+				target TEXT NOT NULL,
+				FOREIGN KEY(service_id) REFERENCES services(id)
+			);`,
+		)
+		if err != nil {
+			return err
+		}
+		for _, table := range []string{"headers", "assertions", "history"} {
+			if err := addColumns(tx, table, "step INTEGER NULL"); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 15: composite services
+	func(tx *sql.Tx) error {
+		err := execAll(tx, `CREATE TABLE IF NOT EXISTS members (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id TEXT NOT NULL,
+			member_id TEXT NOT NULL,
+			FOREIGN KEY(service_id) REFERENCES services(id),
+			FOREIGN KEY(member_id) REFERENCES services(id)
+		);`)
+		if err != nil {
+			return err
+		}
+		return addColumns(tx, "services", "rule text null")
+	},
+	// 16: dependencies between services
+	func(tx *sql.Tx) error {
+		return execAll(tx, `CREATE TABLE IF NOT EXISTS dependencies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id TEXT NOT NULL,
+			parent_id TEXT NOT NULL,
+			FOREIGN KEY(service_id) REFERENCES services(id),
+			FOREIGN KEY(parent_id) REFERENCES services(id)
+		);`)
+	},
+	// 17: retries and confirmation, attempts of retried checks
+	func(tx *sql.Tx) error {
+		if err := addColumns(tx, "services",
+			"retries text null",
+			"retry_backoff text null",
+			"failure_threshold text null",
+			"recovery_threshold text null",
+		); err != nil {
+			return err
+		}
+		if err := addColumns(tx, "history",
+			"observed_state TEXT NULL",
+			"attempts INTEGER NULL",
+		); err != nil {
+			return err
+		}
+		return execAll(tx, `CREATE TABLE IF NOT EXISTS attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			history_id INTEGER NOT NULL,
+			service_id TEXT NOT NULL,
+			state TEXT NOT NULL,
+			latency_ms INTEGER NOT NULL,
+			status_code INTEGER NOT NULL,
+			error_category TEXT NOT NULL,
+			error_message TEXT NOT NULL,
+			timestamp DATETIME NOT NULL,
+			FOREIGN KEY(history_id) REFERENCES history(id),
+			FOREIGN KEY(service_id) REFERENCES services(id)
+		);`)
+	},
+	// 18: history lookups by service, newest first
+	func(tx *sql.Tx) error {
+		return execAll(tx, `CREATE INDEX IF NOT EXISTS history_service_time ON history(service_id, timestamp);`)
+	},
+	// 19: attempts lookups by retried check
+	func(tx *sql.Tx) error {
+		return execAll(tx, `CREATE INDEX IF NOT EXISTS attempts_history ON attempts(history_id);`)
+	},
+}
+
+// migrate brings the schema up to date, applying the migrations newer than
+// the version recorded in schema_version.
+func (s *Store) migrate() error {
+	if _, err := s.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL);`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := s.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version;`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this goardian (%d)", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err := s.applyMigration(i+1, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) applyMigration(version int, m migration) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM schema_version;`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (?);`, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func execAll(tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumns adds columns given as "name definition" to a table, skipping
+// the ones it already has. Databases created before schema versioning may
+// already have some of them.
+func addColumns(tx *sql.Tx, table string, columns ...string) error {
+	existing, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		var name string
+		fmt.Sscan(column, &name)
+		if slices.Contains(existing, name) {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", table, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableColumns lists the column names of a table
+func tableColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
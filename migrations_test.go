@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// createBaselineDB writes a database at path with the schema and rows of
+// versions that predate migrations
+func createBaselineDB(t *testing.T, path string, services, history int) {
+	t.Helper()
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stmts := []string{
+		`CREATE TABLE services (
+			id text not null primary key,
+			name text not null,
+			method text not null,
+			endpoint text not null,
+			payload text not null,
+			request_delay text not null,
+			json_property text null,
+			expected_value text null,
+			preferred_status text null,
+			insecure_skip_verify text null
+		);`,
+		`CREATE TABLE history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id TEXT NOT NULL,
+			status BOOLEAN NOT NULL,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(service_id) REFERENCES services(id)
+		);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := []string{"api", "web", "db"}
+	for i := range services {
+		if _, err := conn.Exec(`INSERT INTO services VALUES (?, ?, 'GET', 'https://example.com', '', '5000', 'status', 'ok', '200', 'false');`, names[i], names[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range history {
+		if _, err := conn.Exec(`INSERT INTO history (service_id, status, timestamp) VALUES ('api', ?, datetime('now', ?));`, i%2 == 0, fmt.Sprintf("-%d minutes", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInitMigratesBaseline(t *testing.T) {
+	t.Chdir(t.TempDir())
+	createBaselineDB(t, dbPath, 2, 3)
+
+	// Migrating again must be a no-op
+	for range 2 {
+		store := new(Store)
+		if err := store.Init(); err != nil {
+			t.Fatalf("Init: %v", err)
+		}
+
+		var version int
+		if err := store.conn.QueryRow(`SELECT version FROM schema_version;`).Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		if version != len(migrations) {
+			t.Errorf("schema version = %d, want %d", version, len(migrations))
+		}
+
+		services, err := store.GetServices()
+		if err != nil {
+			t.Fatalf("GetServices: %v", err)
+		}
+		if len(services) != 2 {
+			t.Fatalf("got %d services, want 2", len(services))
+		}
+		for _, s := range services {
+			if s.JSONProperty != "status" || s.ExpectedValue != "ok" || s.PreferredStatus != "200" {
+				t.Errorf("service %s lost its columns: %+v", s.Name, s)
+			}
+			want := 0
+			if s.ID == "api" {
+				want = 3
+			}
+			if len(s.StatusHistory) != want {
+				t.Errorf("service %s has %d history rows, want %d", s.Name, len(s.StatusHistory), want)
+			}
+		}
+		// Legacy rows only know up or down
+		for _, s := range services {
+			if s.ID == "api" && s.StatusHistory[0].Status != StatusOnline {
+				t.Errorf("latest check of api = %s, want %s", s.StatusHistory[0].Status, StatusOnline)
+			}
+		}
+		store.conn.Close()
+	}
+}
+
+// countRows returns the number of rows of a table of the database at path
+func countRows(path, table string) (int, error) {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var n int
+	err = conn.QueryRow(`SELECT COUNT(*) FROM ` + table + `;`).Scan(&n)
+	return n, err
+}
+
+func TestRecoverLegacyBackup(t *testing.T) {
+	const (
+		missing = iota // No goardian.db
+		empty          // A goardian.db without tables
+		rows           // A goardian.db with the given rows
+	)
+	tests := []struct {
+		name        string
+		current     int
+		rows        [2]int // Services and history rows of goardian.db
+		backup      [2]int
+		wantRestore bool
+	}{
+		{name: "backup only", current: missing, backup: [2]int{2, 3}, wantRestore: true},
+		{name: "restore never started", current: empty, backup: [2]int{2, 3}, wantRestore: true},
+		{name: "complete restore", current: rows, rows: [2]int{2, 3}, backup: [2]int{2, 3}},
+		{name: "checks after restore", current: rows, rows: [2]int{2, 3}, backup: [2]int{2, 1}},
+		{name: "service deleted after restore", current: rows, rows: [2]int{1, 3}, backup: [2]int{2, 3}},
+		{name: "history cleared after restore", current: rows, rows: [2]int{2, 0}, backup: [2]int{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			createBaselineDB(t, legacyBackupPath, tt.backup[0], tt.backup[1])
+			switch tt.current {
+			case empty:
+				if err := os.WriteFile(dbPath, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			case rows:
+				createBaselineDB(t, dbPath, tt.rows[0], tt.rows[1])
+			}
+
+			if err := recoverLegacyBackup(); err != nil {
+				t.Fatalf("recoverLegacyBackup: %v", err)
+			}
+
+			want := tt.rows
+			if tt.wantRestore {
+				want = tt.backup
+			}
+			for i, table := range []string{"services", "history"} {
+				n, err := countRows(dbPath, table)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != want[i] {
+					t.Errorf("%s has %d %s rows, want %d", dbPath, n, table, want[i])
+				}
+			}
+			_, err := os.Stat(legacyBackupPath)
+			if kept := err == nil; kept == tt.wantRestore {
+				t.Errorf("backup kept = %v, want %v", kept, !tt.wantRestore)
+			}
+			_, err = os.Stat(partialRestorePath)
+			if partial := err == nil; partial != (tt.current == empty) {
+				t.Errorf("empty database kept = %v, want %v", partial, tt.current == empty)
+			}
+		})
+	}
+}
+
+func TestRecoverLegacyBackupKeepsMigratedDB(t *testing.T) {
+	t.Chdir(t.TempDir())
+	createBaselineDB(t, legacyBackupPath, 2, 3)
+
+	store := new(Store)
+	if err := store.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if _, err := store.conn.Exec(`DELETE FROM history;`); err != nil {
+		t.Fatal(err)
+	}
+	store.conn.Close()
+
+	// A backup from an older version shows up again next to the migrated
+	// database, which has fewer rows but must be kept
+	createBaselineDB(t, legacyBackupPath, 2, 3)
+	if err := recoverLegacyBackup(); err != nil {
+		t.Fatalf("recoverLegacyBackup: %v", err)
+	}
+	if n, err := countRows(dbPath, "history"); err != nil || n != 0 {
+		t.Errorf("history rows = %d (%v), want 0", n, err)
+	}
+}
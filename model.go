@@ -1,11 +1,13 @@
 package main
 
 import (
-	"crypto/tls"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -14,23 +16,65 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/google/uuid"
+)
+
+const (
+	statusHistoryLimit = 20
+	// Checks plotted in the detail view latency sparkline
+	detailHistoryLimit = 60
+	// Checks listed in the detail view check log
+	detailLogLimit = 10
 )
 
 const (
 	listView uint = iota
+	detailView
 	nameView
+	typeView
 	methodView
 	endpointView
 	payloadView
+	bannerView
+	resolverView
+	recordTypeView
+	pingCountView
+	grpcServiceView
+	grpcTLSView
+	stepsView
+	stepRequestView
+	stepPayloadView
+	stepHeadersView
+	extractionsView
+	stepAssertionsView
+	membersView
+	ruleView
+	dependenciesView
+	retriesView
+	retryBackoffView
+	failureThresholdView
+	recoveryThresholdView
+	headersView
+	authTypeView
+	authUsernameView
+	authHeaderView
+	authSecretView
 	requestDelayView
+	timeoutView
+	degradedThresholdView
+	downThresholdView
 	jsonPropertyView
 	expectedValueView
+	assertionsView
 	preferredStatusView
 	insecureSkipVerifyView
+	certWarningDaysView
+	certCriticalDaysView
 )
 
 type model struct {
 	store        *Store
+	scheduler    *Scheduler
 	state        uint
 	textinput    textinput.Model
 	spinner      spinner.Model
@@ -38,13 +82,39 @@ type model struct {
 	currService  Service
 	services     []Service
 	listIndex    int
+	stepIndex    int // Step of a multistep service edited by the wizard
 	errorMsg     string
+	detail       serviceDetail
+}
+
+// serviceDetail holds the history shown in the detail view of a service
+type serviceDetail struct {
+	history []Check     // Newest first
+	uptime  [3]*float64 // 24h, 7d and 30d, nil when there are no checks
+}
+
+var uptimeWindows = [3]struct {
+	label  string
+	period time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
 }
 
-// type tickMsg time.Time
 type dataMsg []Service
 
-func NewModel(store *Store) model {
+// resultMsg carries a check result from the scheduler
+type resultMsg Result
+
+// detailMsg carries the history of a service loaded for the detail view
+type detailMsg struct {
+	id       string
+	detail   serviceDetail
+	errorMsg string
+}
+
+func NewModel(store *Store, scheduler *Scheduler) model {
 	services, err := store.GetServices()
 	if err != nil {
 		log.Fatalf("Unable to get services: %v", err)
@@ -59,11 +129,12 @@ func NewModel(store *Store) model {
 
 	return model{
 		store:        store,
+		scheduler:    scheduler,
 		state:        listView,
 		textinput:    textinput.New(),
 		spinner:      s,
 		pulseSpinner: ps,
-		services:     services,
+		services:     dependencyTree(services),
 		errorMsg:     "",
 	}
 }
@@ -73,36 +144,11 @@ func (m model) Init() tea.Cmd {
 		m.spinner.Tick,
 		m.pulseSpinner.Tick,
 		func() tea.Msg {
-			return dataMsg(m.services)
+			return dataMsg(refreshServices(m))
 		},
 	)
 }
 
-func getUpdatedServices(m model) ([]Service, error) {
-	services, err := m.store.GetServices()
-	if err != nil {
-		return nil, err
-	}
-	for i := range services {
-		s := services[i]
-		status := getStatus(s)
-
-		// Prune history older than 1 month
-		now := time.Now()
-		oneMonthAgo := now.AddDate(0, -1, 0)
-		_, err := m.store.conn.Exec(
-			`DELETE FROM history WHERE service_id = ? AND timestamp < ?`,
-			s.ID, oneMonthAgo.Format("2006-01-02 15:04:05"),
-		)
-		if err != nil {
-			log.Printf("Failed to prune history for service %s: %v", s.ID, err)
-		}
-
-		m.store.SaveHistory(s, status)
-	}
-	return services, nil
-}
-
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var (
 		cmds []tea.Cmd
@@ -115,14 +161,44 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case dataMsg:
 		m.services = []Service(msg)
-		return m, tea.Tick(5*time.Second, func(_ time.Time) tea.Msg {
-			m.services = refreshServices(m)
-			return dataMsg(m.services)
-		})
+		if m.listIndex >= len(m.services) {
+			m.listIndex = max(len(m.services)-1, 0)
+		}
+		return m, nil
+	case resultMsg:
+		var detailCmd tea.Cmd
+		for i := range m.services {
+			s := &m.services[i]
+			if s.ID != msg.Service.ID {
+				continue
+			}
+			// History is kept newest first
+			s.StatusHistory = append([]Check{msg.Check}, s.StatusHistory...)
+			if len(s.StatusHistory) > statusHistoryLimit {
+				s.StatusHistory = s.StatusHistory[:statusHistoryLimit]
+			}
+			s.LastStatusInfo = statusInfo(s.StatusHistory)
+			if m.state == detailView && i == m.listIndex {
+				detailCmd = m.loadDetail()
+			}
+		}
+		return m, detailCmd
+	case detailMsg:
+		// The selection may have changed while the history was loading
+		if m.state != detailView || m.listIndex >= len(m.services) || m.services[m.listIndex].ID != msg.id {
+			return m, nil
+		}
+		m.detail = msg.detail
+		m.errorMsg = msg.errorMsg
+		return m, nil
 	case tea.KeyMsg:
 		key := msg.String()
 		switch m.state {
 		case listView:
+			// Everything but creating a service or quitting needs a selection
+			if len(m.services) == 0 && key != "n" && key != "q" {
+				break
+			}
 			switch key {
 			case "q":
 				return m, tea.Quit
@@ -137,10 +213,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.listIndex >= len(m.services)-1 && m.listIndex > 0 {
 					m.listIndex--
 				}
-				return m, func() tea.Msg {
-					m.services = refreshServices(m)
-					return dataMsg(m.services)
-				}
+				return m, m.reload
 			case "up", "k":
 				if m.listIndex > 0 {
 					m.listIndex--
@@ -150,15 +223,34 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.listIndex++
 				}
 			case "enter":
-				m.currService = m.services[m.listIndex]
-				m.state = nameView
-				m.textinput.SetValue(m.currService.Name)
-				m.textinput.Focus()
-				m.textinput.CursorEnd()
+				m.state = detailView
+				m.detail = serviceDetail{}
+				m.errorMsg = ""
+				return m, m.loadDetail()
+			case "e":
+				m.edit()
 			case "ctrl+r":
 				s := &m.services[m.listIndex]
 				s.LastStatusInfo = ""
+				s.StatusHistory = []Check{}
 				m.store.DeleteAllHistory(*s)
+				// Consecutive checks counted before the history was cleared
+				// no longer apply
+				id := s.ID
+				return m, func() tea.Msg {
+					if err := m.scheduler.Reset(id); err != nil {
+						log.Printf("Unable to reset service %s: %v", id, err)
+					}
+					return nil
+				}
+			}
+
+		case detailView:
+			switch key {
+			case "e":
+				m.edit()
+			case "esc", "q":
+				m.state = listView
 			}
 
 		case nameView:
@@ -171,12 +263,99 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					break
 				}
 				m.currService.Name = name
-				m.state = methodView
-				m.SetFieldValue("Method")
+				m.state = dependenciesView
+				m.textinput.SetValue("")
 			case "esc":
 				m.state = listView
 			}
 
+		case typeView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				serviceType := strings.ToLower(strings.TrimSpace(m.textinput.Value()))
+				if serviceType == "" {
+					serviceType = typeHTTP
+				}
+				switch serviceType {
+				case typeHTTP:
+					m.state = methodView
+					m.SetFieldValue("Method")
+				case typeTCP, typeDNS, typeICMP, typeGRPC, typeWebSocket:
+					m.state = endpointView
+					m.SetFieldValue("Endpoint")
+				case typeMultiStep:
+					m.state = stepsView
+					m.textinput.SetValue("")
+				case typeComposite:
+					m.state = membersView
+					m.textinput.SetValue("")
+				default:
+					m.errorMsg = "Invalid service type (http, tcp, dns, icmp, grpc, websocket, multistep, composite)"
+					return m, tea.Batch(cmds...)
+				}
+				m.currService.Type = serviceType
+			case "esc":
+				m.state = dependenciesView
+				m.textinput.SetValue("")
+			}
+
+		case dependenciesView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				input := strings.TrimSpace(m.textinput.Value())
+				// An empty input finishes the dependencies list
+				if input == "" {
+					// New services get their ID now to be part of the graph
+					if m.currService.ID == "" {
+						m.currService.ID = uuid.New().String()
+					}
+					services := []Service{m.currService}
+					for _, s := range m.services {
+						if s.ID != m.currService.ID {
+							services = append(services, s)
+						}
+					}
+					if err := dependencyCycle(services); err != nil {
+						m.errorMsg = "Invalid dependencies: " + err.Error()
+						break
+					}
+					m.state = typeView
+					m.SetFieldValue("Type")
+					break
+				}
+				// -N removes the Nth dependency
+				if n, err := strconv.Atoi(input); err == nil && n < 0 {
+					if -n > len(m.currService.Parents) {
+						m.errorMsg = "No such dependency"
+						break
+					}
+					m.currService.Parents = slices.Delete(slices.Clone(m.currService.Parents), -n-1, -n)
+					m.textinput.SetValue("")
+					break
+				}
+				i := slices.IndexFunc(m.services, func(s Service) bool { return strings.EqualFold(s.Name, input) })
+				if i < 0 {
+					m.errorMsg = "No service named " + input
+					break
+				}
+				parent := m.services[i]
+				if parent.ID == m.currService.ID || parent.Name == m.currService.Name {
+					m.errorMsg = "A service can't depend on itself"
+					break
+				}
+				if slices.Contains(m.currService.Parents, parent.ID) {
+					m.errorMsg = "Already depends on " + parent.Name
+					break
+				}
+				m.currService.Parents = append(slices.Clone(m.currService.Parents), parent.ID)
+				m.textinput.SetValue("")
+			case "esc":
+				m.state = nameView
+				m.SetFieldValue("Name")
+			}
+
 		case methodView:
 			switch key {
 			case "enter":
@@ -190,7 +369,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.state = endpointView
 				m.SetFieldValue("Endpoint")
 			case "esc":
-				m.state = nameView
+				m.state = typeView
+				m.SetFieldValue("Type")
 			}
 
 		case endpointView:
@@ -198,15 +378,57 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "enter":
 				m.errorMsg = ""
 				endpoint := strings.TrimSpace(m.textinput.Value())
-				if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
-					m.errorMsg = "Invalid endpoint (http:// or https://)"
-					break
+				switch m.currService.Type {
+				case typeTCP, typeGRPC:
+					if !validTCPEndpoint(endpoint) {
+						m.errorMsg = "Invalid endpoint (host:port)"
+						return m, tea.Batch(cmds...)
+					}
+				case typeDNS:
+					if !validDomain(endpoint) {
+						m.errorMsg = "Invalid endpoint (domain name)"
+						return m, tea.Batch(cmds...)
+					}
+				case typeICMP:
+					if !validHost(endpoint) {
+						m.errorMsg = "Invalid endpoint (host name or IP address)"
+						return m, tea.Batch(cmds...)
+					}
+				case typeWebSocket:
+					if !validWebSocketEndpoint(endpoint) {
+						m.errorMsg = "Invalid endpoint (ws:// or wss://)"
+						return m, tea.Batch(cmds...)
+					}
+				default:
+					if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+						m.errorMsg = "Invalid endpoint (http:// or https://)"
+						return m, tea.Batch(cmds...)
+					}
 				}
 				m.currService.Endpoint = endpoint
-				m.state = payloadView
-				m.SetFieldValue("Payload")
+				switch m.currService.Type {
+				case typeDNS:
+					m.state = resolverView
+					m.SetFieldValue("Resolver")
+				case typeICMP:
+					m.state = pingCountView
+					m.SetFieldValue("PingCount")
+				case typeGRPC:
+					m.state = grpcServiceView
+					m.SetFieldValue("GRPCService")
+				default:
+					m.state = payloadView
+					m.SetFieldValue("Payload")
+				}
 			case "esc":
-				m.state = methodView
+				// Only HTTP services ask for a method
+				if m.currService.Type != "" && m.currService.Type != typeHTTP {
+					m.state = typeView
+					m.SetFieldValue("Type")
+				} else {
+					m.state = methodView
+					m.SetFieldValue("Method")
+				}
 			}
 
 		case payloadView:
@@ -216,242 +438,1136 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				payload := strings.TrimSpace(m.textinput.Value())
 				// Payload can be empty, so we allow it as-is
 				m.currService.Payload = payload
-				m.state = requestDelayView
-				m.SetFieldValue("RequestDelay")
+				if m.currService.Type == typeTCP {
+					m.state = bannerView
+					m.SetFieldValue("Banner")
+				} else {
+					m.state = headersView
+					m.SetFieldValue("Headers")
+				}
 			case "esc":
 				m.state = endpointView
 				m.SetFieldValue("Endpoint")
 			}
 
-		case requestDelayView:
+		case resolverView:
 			switch key {
 			case "enter":
 				m.errorMsg = ""
-				requestDelay := strings.TrimSpace(m.textinput.Value())
-				// Request delay can be empty, so we allow it as-is
-				if requestDelay != "" {
-					if _, err := strconv.Atoi(requestDelay); err != nil {
-						m.errorMsg = "Invalid request delay (integer)"
-						break
-					}
+				resolver := strings.TrimSpace(m.textinput.Value())
+				if !validResolver(resolver) {
+					m.errorMsg = "Invalid resolver (host or host:port)"
+					break
 				}
-				m.currService.RequestDelay = requestDelay
-				m.state = jsonPropertyView
-				m.SetFieldValue("JSONProperty")
+				m.currService.Resolver = resolver
+				m.state = recordTypeView
+				m.SetFieldValue("RecordType")
 			case "esc":
 				m.state = endpointView
-				m.SetFieldValue("Payload")
+				m.SetFieldValue("Endpoint")
 			}
 
-		case jsonPropertyView:
+		case recordTypeView:
 			switch key {
 			case "enter":
 				m.errorMsg = ""
-				jsonProperty := strings.TrimSpace(m.textinput.Value())
-				m.currService.JSONProperty = jsonProperty
-				if jsonProperty != "" {
-					m.state = expectedValueView
-					m.SetFieldValue("ExpectedValue")
-				} else {
-					m.state = preferredStatusView
-					m.SetFieldValue("PreferredStatus")
+				recordType := strings.ToUpper(strings.TrimSpace(m.textinput.Value()))
+				if recordType == "" {
+					recordType = defaultRecordType
+				}
+				if !slices.Contains(dnsRecordTypes, recordType) {
+					m.errorMsg = "Invalid record type (" + strings.Join(dnsRecordTypes, ", ") + ")"
+					break
 				}
+				m.currService.RecordType = recordType
+				m.state = requestDelayView
+				m.SetFieldValue("RequestDelay")
+			case "esc":
+				m.state = resolverView
+				m.SetFieldValue("Resolver")
+			}
+
+		case grpcServiceView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				// A blank service name checks the whole server
+				m.currService.GRPCService = strings.TrimSpace(m.textinput.Value())
+				m.state = grpcTLSView
+				m.SetFieldValue("GRPCTLS")
 			case "esc":
 				m.state = endpointView
 				m.SetFieldValue("Endpoint")
 			}
 
-		case expectedValueView:
+		case grpcTLSView:
 			switch key {
 			case "enter":
 				m.errorMsg = ""
-				expectedValue := strings.TrimSpace(m.textinput.Value())
-				m.currService.ExpectedValue = expectedValue
-				m.state = insecureSkipVerifyView
-				m.SetFieldValue("InsecureSkipVerify")
+				useTLS := strings.ToLower(strings.TrimSpace(m.textinput.Value()))
+				if useTLS == "" {
+					useTLS = "false"
+				}
+				if useTLS != "true" && useTLS != "false" {
+					m.errorMsg = "Invalid TLS (true/false)"
+					break
+				}
+				m.currService.GRPCTLS = useTLS
+				if useTLS == "true" {
+					m.state = insecureSkipVerifyView
+					m.SetFieldValue("InsecureSkipVerify")
+				} else {
+					m.state = headersView
+					m.SetFieldValue("Headers")
+				}
 			case "esc":
-				m.state = jsonPropertyView
-				m.SetFieldValue("JSONProperty")
+				m.state = grpcServiceView
+				m.SetFieldValue("GRPCService")
 			}
 
-		case preferredStatusView:
+		case stepsView:
 			switch key {
 			case "enter":
 				m.errorMsg = ""
-				preferredStatus := strings.TrimSpace(m.textinput.Value())
-				if preferredStatus == "" {
-					m.errorMsg = "Preferred status cannot be empty (100-599)"
+				input := strings.TrimSpace(m.textinput.Value())
+				// An empty input finishes the steps list
+				if input == "" {
+					if err := validateSteps(m.currService.Steps); err != nil {
+						m.errorMsg = "Invalid steps: " + err.Error()
+						break
+					}
+					m.currService.Endpoint = m.currService.Steps[0].Endpoint
+					m.state = requestDelayView
+					m.SetFieldValue("RequestDelay")
+					break
+				}
+				// -N removes the Nth step and N edits it
+				if n, err := strconv.Atoi(input); err == nil {
+					if n == 0 || n > len(m.currService.Steps) || -n > len(m.currService.Steps) {
+						m.errorMsg = "No such step"
+						break
+					}
+					if n < 0 {
+						m.currService.Steps = slices.Delete(slices.Clone(m.currService.Steps), -n-1, -n)
+						m.textinput.SetValue("")
+						break
+					}
+					m.stepIndex = n - 1
+					m.state = stepRequestView
+					m.textinput.SetValue(m.currService.Steps[m.stepIndex].String())
+					m.textinput.CursorEnd()
 					break
 				}
-				statusCode, err := strconv.Atoi(preferredStatus)
-				if err != nil || statusCode < 100 || statusCode > 599 {
-					m.errorMsg = "Invalid preferred status (100-599)"
+				step, err := parseStep(input)
+				if err != nil {
+					m.errorMsg = "Invalid step: " + err.Error()
 					break
 				}
-				m.currService.PreferredStatus = preferredStatus
-				m.state = insecureSkipVerifyView
-				m.SetFieldValue("InsecureSkipVerify")
+				m.currService.Steps = append(slices.Clone(m.currService.Steps), step)
+				m.stepIndex = len(m.currService.Steps) - 1
+				m.state = stepPayloadView
+				m.textinput.SetValue("")
 			case "esc":
-				m.state = jsonPropertyView
-				m.SetFieldValue("JSONProperty")
+				m.state = typeView
+				m.SetFieldValue("Type")
 			}
 
-		case insecureSkipVerifyView:
+		case stepRequestView:
 			switch key {
 			case "enter":
 				m.errorMsg = ""
-				insecureSkipVerify := strings.TrimSpace(m.textinput.Value())
-				lower := strings.ToLower(insecureSkipVerify)
-				if lower != "true" && lower != "false" {
-					m.errorMsg = "Invalid insecure skip verify (true/false)"
+				edited, err := parseStep(strings.TrimSpace(m.textinput.Value()))
+				if err != nil {
+					m.errorMsg = "Invalid step: " + err.Error()
 					break
 				}
-				m.currService.InsecureSkipVerify = lower
-				m.store.SaveService(m.currService)
-				m.state = listView
-				return m, func() tea.Msg {
-					m.services = refreshServices(m)
-					return dataMsg(m.services)
-				}
+				step := m.currService.Steps[m.stepIndex]
+				step.Name, step.Method, step.Endpoint, step.PreferredStatus = edited.Name, edited.Method, edited.Endpoint, edited.PreferredStatus
+				m.setStep(step)
+				m.state = stepPayloadView
+				m.textinput.SetValue(step.Payload)
+				m.textinput.CursorEnd()
 			case "esc":
-				m.state = preferredStatusView
-				m.SetFieldValue("PreferredStatus")
+				m.state = stepsView
+				m.textinput.SetValue("")
 			}
-		}
-	default:
-		m.spinner, cmd = m.spinner.Update(msg)
-		cmds = append(cmds, cmd)
-		m.pulseSpinner, cmd = m.pulseSpinner.Update(msg)
-		cmds = append(cmds, cmd)
-		return m, tea.Batch(cmds...)
-	}
-	return m, tea.Batch(cmds...)
-}
 
-func refreshServices(m model) []Service {
-	services, err := getUpdatedServices(m)
-	if err != nil {
-		log.Fatalf("Unable to get services: %v", err)
-	}
-
-	for i := range services {
-		s := services[i]
-		if len(s.StatusHistory) == 0 {
-			continue
-		}
-
-		limit := 20
-
-		// Add current status value to StatusHistory
-		lenght := len(s.StatusHistory) + 1
-		if lenght >= limit {
-			s.StatusHistory = s.StatusHistory[:limit-1]
-		}
-
-		// Create status bar info
-		statusBar := "- "
-		if s.StatusHistory[len(s.StatusHistory)-1] {
-			statusBar += lipgloss.NewStyle().Background(lipgloss.Color("2")).Padding(0, 1).Render("Online")
-		} else {
-			statusBar += lipgloss.NewStyle().Background(lipgloss.Color("1")).Padding(0, 1).Render("Offline")
-		}
-
-		// Health bar
-		for _, v := range s.StatusHistory {
-			if v {
-				statusBar += " " + lipgloss.NewStyle().Background(lipgloss.Color("2")).Render(" ")
-			} else {
-				statusBar += " " + lipgloss.NewStyle().Background(lipgloss.Color("1")).Render(" ")
+		case stepPayloadView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				step := m.currService.Steps[m.stepIndex]
+				step.Payload = strings.TrimSpace(m.textinput.Value())
+				m.setStep(step)
+				m.state = stepHeadersView
+				m.textinput.SetValue("")
+			case "esc":
+				m.state = stepsView
+				m.textinput.SetValue("")
 			}
-		}
-
-		services[i].LastStatusInfo = statusBar
-	}
-
-	return services
-}
-
-func (m *model) SetFieldValue(p string) {
-	v := reflect.ValueOf(m.currService)
-	field := v.FieldByName(p)
-	var value string
-	if field.IsValid() && field.Kind() == reflect.String {
-		value = field.String()
-	}
-	if value != "" {
-		m.textinput.SetValue(value)
-	} else {
-		m.textinput.SetValue("")
-	}
-	m.textinput.Focus()
-	m.textinput.CursorEnd()
-}
 
-func getStatus(s Service) bool {
-	if len(s.StatusHistory) > 0 {
-		delay := 0
-		requestDelay := s.RequestDelay
-		if requestDelay != "" {
-			conv, err := strconv.Atoi(requestDelay)
-			if err == nil {
-				delay = conv
+		case stepHeadersView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				input := strings.TrimSpace(m.textinput.Value())
+				// An empty input finishes the headers list
+				if input == "" {
+					m.state = extractionsView
+					break
+				}
+				header, err := parseHeader(input)
+				if err != nil {
+					m.errorMsg = err.Error()
+					break
+				}
+				step := m.currService.Steps[m.stepIndex]
+				step.Headers = setHeader(step.Headers, header)
+				m.setStep(step)
+				m.textinput.SetValue("")
+			case "esc":
+				m.state = stepPayloadView
+				m.textinput.SetValue(m.currService.Steps[m.stepIndex].Payload)
+				m.textinput.CursorEnd()
 			}
-		}
-
-		time.Sleep(time.Duration(time.Duration(delay).Milliseconds()))
-	}
-
-	isv := s.InsecureSkipVerify == "true"
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: isv},
-	}
-	client := &http.Client{Transport: tr}
-
-	status := false
-	var resp *http.Response
-	var err error
-	if strings.ToUpper(strings.TrimSpace(s.Method)) == "GET" {
-		resp, err = client.Get(s.Endpoint)
-		status = err == nil
-	}
-
-	if resp == nil {
-		return false
-	}
-
-	defer resp.Body.Close()
 
-	if s.JSONProperty != "" {
-		var jsonData map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&jsonData); err == nil {
-			keys := strings.Split(s.JSONProperty, ".")
-			var v interface{} = jsonData
-			jsonPropertyExists := true
-
-			for _, k := range keys {
-				m, ok := v.(map[string]interface{})
-				if !ok {
-					jsonPropertyExists = false
+		case extractionsView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				input := strings.TrimSpace(m.textinput.Value())
+				// An empty input finishes the extractions list
+				if input == "" {
+					m.state = stepAssertionsView
 					break
 				}
-				v, ok = m[k]
-				if !ok {
-					jsonPropertyExists = false
+				step := m.currService.Steps[m.stepIndex]
+				// -N removes the Nth extraction
+				if n, err := strconv.Atoi(input); err == nil && n < 0 {
+					if -n > len(step.Extractions) {
+						m.errorMsg = "No such extraction"
+						break
+					}
+					step.Extractions = slices.Delete(slices.Clone(step.Extractions), -n-1, -n)
+					m.setStep(step)
+					m.textinput.SetValue("")
+					break
+				}
+				extraction, err := parseExtraction(input)
+				if err != nil {
+					m.errorMsg = "Invalid extraction: " + err.Error()
 					break
 				}
+				step.Extractions = append(slices.Clone(step.Extractions), extraction)
+				m.setStep(step)
+				m.textinput.SetValue("")
+			case "esc":
+				m.state = stepHeadersView
+				m.textinput.SetValue("")
 			}
 
-			// Only set status to true if we successfully navigated through all keys
-			status = jsonPropertyExists && (v == s.ExpectedValue || s.ExpectedValue == "")
-		} else {
-			status = false
-		}
-	}
-
-	var preferredStatus int
-	preferredStatus, err = strconv.Atoi(s.PreferredStatus)
-	if err != nil {
-		preferredStatus = 200
+		case stepAssertionsView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				input := strings.TrimSpace(m.textinput.Value())
+				// An empty input finishes the step
+				if input == "" {
+					m.state = stepsView
+					break
+				}
+				step := m.currService.Steps[m.stepIndex]
+				// -N removes the Nth assertion
+				if n, err := strconv.Atoi(input); err == nil && n < 0 {
+					if -n > len(step.Assertions) {
+						m.errorMsg = "No such assertion"
+						break
+					}
+					step.Assertions = slices.Delete(slices.Clone(step.Assertions), -n-1, -n)
+					m.setStep(step)
+					m.textinput.SetValue("")
+					break
+				}
+				assertion, err := parseAssertion(input)
+				if err != nil {
+					m.errorMsg = "Invalid assertion: " + err.Error()
+					break
+				}
+				if !assertion.supports(typeHTTP) {
+					m.errorMsg = "Invalid assertion: " + assertion.Source + " assertions don't apply to HTTP requests"
+					break
+				}
+				step.Assertions = append(slices.Clone(step.Assertions), assertion)
+				m.setStep(step)
+				m.textinput.SetValue("")
+			case "esc":
+				m.state = extractionsView
+				m.textinput.SetValue("")
+			}
+
+		case membersView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				input := strings.TrimSpace(m.textinput.Value())
+				// An empty input finishes the members list
+				if input == "" {
+					if len(m.currService.Members) == 0 {
+						m.errorMsg = "Composite services need at least one member"
+						break
+					}
+					m.state = ruleView
+					m.SetFieldValue("Rule")
+					break
+				}
+				// -N removes the Nth member
+				if n, err := strconv.Atoi(input); err == nil && n < 0 {
+					if -n > len(m.currService.Members) {
+						m.errorMsg = "No such member"
+						break
+					}
+					m.currService.Members = slices.Delete(slices.Clone(m.currService.Members), -n-1, -n)
+					m.textinput.SetValue("")
+					break
+				}
+				i := slices.IndexFunc(m.services, func(s Service) bool { return strings.EqualFold(s.Name, input) })
+				if i < 0 {
+					m.errorMsg = "No service named " + input
+					break
+				}
+				member := m.services[i]
+				if member.ID == m.currService.ID || member.Name == m.currService.Name {
+					m.errorMsg = "A service can't be a member of itself"
+					break
+				}
+				if slices.Contains(m.currService.Members, member.ID) {
+					m.errorMsg = member.Name + " is already a member"
+					break
+				}
+				m.currService.Members = append(slices.Clone(m.currService.Members), member.ID)
+				m.textinput.SetValue("")
+			case "esc":
+				m.state = typeView
+				m.SetFieldValue("Type")
+			}
+
+		case ruleView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				rule := strings.ToLower(strings.TrimSpace(m.textinput.Value()))
+				if rule == "" {
+					rule = ruleAll
+				}
+				if !validRule(rule) {
+					m.errorMsg = "Invalid rule (all, any or a number of members)"
+					break
+				}
+				if quorum(rule, len(m.currService.Members)) > len(m.currService.Members) {
+					m.errorMsg = fmt.Sprintf("Invalid rule, only %d members", len(m.currService.Members))
+					break
+				}
+				m.currService.Rule = rule
+				m.state = requestDelayView
+				m.SetFieldValue("RequestDelay")
+			case "esc":
+				m.state = membersView
+				m.textinput.SetValue("")
+			}
+
+		case pingCountView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				count := strings.TrimSpace(m.textinput.Value())
+				if !validPingCount(count) {
+					m.errorMsg = "Invalid packet count (1-" + strconv.Itoa(maxPingCount) + ")"
+					break
+				}
+				m.currService.PingCount = count
+				m.state = requestDelayView
+				m.SetFieldValue("RequestDelay")
+			case "esc":
+				m.state = endpointView
+				m.SetFieldValue("Endpoint")
+			}
+
+		case bannerView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				banner := strings.TrimSpace(m.textinput.Value())
+				if _, err := regexp.Compile(banner); err != nil {
+					m.errorMsg = "Invalid banner regex: " + err.Error()
+					break
+				}
+				m.currService.Banner = banner
+				m.state = requestDelayView
+				m.SetFieldValue("RequestDelay")
+			case "esc":
+				m.state = payloadView
+				m.SetFieldValue("Payload")
+			}
+
+		case headersView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				input := strings.TrimSpace(m.textinput.Value())
+				// An empty input finishes the headers list
+				if input == "" && m.currService.Type == typeGRPC {
+					m.state = requestDelayView
+					m.SetFieldValue("RequestDelay")
+					break
+				}
+				if input == "" {
+					m.state = authTypeView
+					m.SetFieldValue("AuthType")
+					break
+				}
+				header, err := parseHeader(input)
+				if err != nil {
+					m.errorMsg = err.Error()
+					break
+				}
+				m.currService.Headers = setHeader(m.currService.Headers, header)
+				m.textinput.SetValue("")
+			case "esc":
+				if m.currService.Type == typeGRPC && m.currService.GRPCTLS == "true" {
+					m.state = insecureSkipVerifyView
+					m.SetFieldValue("InsecureSkipVerify")
+				} else if m.currService.Type == typeGRPC {
+					m.state = grpcTLSView
+					m.SetFieldValue("GRPCTLS")
+				} else {
+					m.state = payloadView
+					m.SetFieldValue("Payload")
+				}
+			}
+
+		case authTypeView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				authType := strings.ToLower(strings.TrimSpace(m.textinput.Value()))
+				if authType == "" {
+					authType = authNone
+				}
+				switch authType {
+				case authNone:
+					m.currService.AuthUsername = ""
+					m.currService.AuthSecret = ""
+					m.currService.AuthHeader = ""
+					m.state = requestDelayView
+					m.SetFieldValue("RequestDelay")
+				case authBasic:
+					m.state = authUsernameView
+					m.SetFieldValue("AuthUsername")
+				case authBearer:
+					m.state = authSecretView
+					m.SetFieldValue("AuthSecret")
+				case authAPIKey:
+					m.state = authHeaderView
+					m.SetFieldValue("AuthHeader")
+				default:
+					m.errorMsg = "Invalid auth type (none, basic, bearer, apikey)"
+					return m, tea.Batch(cmds...)
+				}
+				m.currService.AuthType = authType
+			case "esc":
+				m.state = headersView
+				m.SetFieldValue("Headers")
+			}
+
+		case authUsernameView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				username := strings.TrimSpace(m.textinput.Value())
+				if username == "" {
+					m.errorMsg = "Username cannot be empty"
+					break
+				}
+				m.currService.AuthUsername = username
+				m.state = authSecretView
+				m.SetFieldValue("AuthSecret")
+			case "esc":
+				m.state = authTypeView
+				m.SetFieldValue("AuthType")
+			}
+
+		case authHeaderView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				header := strings.TrimSpace(m.textinput.Value())
+				if header == "" {
+					header = defaultAPIKeyHeader
+				}
+				if _, err := parseHeader(header + ": x"); err != nil {
+					m.errorMsg = "Invalid header name"
+					break
+				}
+				m.currService.AuthHeader = header
+				m.state = authSecretView
+				m.SetFieldValue("AuthSecret")
+			case "esc":
+				m.state = authTypeView
+				m.SetFieldValue("AuthType")
+			}
+
+		case authSecretView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				secret := strings.TrimSpace(m.textinput.Value())
+				if secret == "" {
+					m.errorMsg = "Secret cannot be empty"
+					break
+				}
+				m.currService.AuthSecret = secret
+				m.state = requestDelayView
+				m.SetFieldValue("RequestDelay")
+			case "esc":
+				switch m.currService.AuthType {
+				case authBasic:
+					m.state = authUsernameView
+					m.SetFieldValue("AuthUsername")
+				case authAPIKey:
+					m.state = authHeaderView
+					m.SetFieldValue("AuthHeader")
+				default:
+					m.state = authTypeView
+					m.SetFieldValue("AuthType")
+				}
+			}
+
+		case requestDelayView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				requestDelay := strings.TrimSpace(m.textinput.Value())
+				// Request delay can be empty, so we allow it as-is
+				if requestDelay != "" {
+					if _, err := strconv.Atoi(requestDelay); err != nil {
+						m.errorMsg = "Invalid check interval (integer)"
+						break
+					}
+				}
+				m.currService.RequestDelay = requestDelay
+				// Composite services make no request to retry
+				if m.currService.Type == typeComposite {
+					m.state = failureThresholdView
+					m.SetFieldValue("FailureThreshold")
+					break
+				}
+				m.state = retriesView
+				m.SetFieldValue("Retries")
+			case "esc":
+				if m.currService.Type == typeTCP {
+					m.state = bannerView
+					m.SetFieldValue("Banner")
+				} else if m.currService.Type == typeDNS {
+					m.state = recordTypeView
+					m.SetFieldValue("RecordType")
+				} else if m.currService.Type == typeICMP {
+					m.state = pingCountView
+					m.SetFieldValue("PingCount")
+				} else if m.currService.Type == typeGRPC {
+					m.state = headersView
+					m.SetFieldValue("Headers")
+				} else if m.currService.Type == typeMultiStep {
+					m.state = stepsView
+					m.textinput.SetValue("")
+				} else if m.currService.Type == typeComposite {
+					m.state = ruleView
+					m.SetFieldValue("Rule")
+				} else if m.currService.AuthType == "" || m.currService.AuthType == authNone {
+					m.state = authTypeView
+					m.SetFieldValue("AuthType")
+				} else {
+					m.state = authSecretView
+					m.SetFieldValue("AuthSecret")
+				}
+			}
+
+		case retriesView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				retries := strings.TrimSpace(m.textinput.Value())
+				if !validRetries(retries) {
+					m.errorMsg = fmt.Sprintf("Invalid retries (0-%d)", maxRetries)
+					break
+				}
+				m.currService.Retries = retries
+				if retryCount(retries) > 0 {
+					m.state = retryBackoffView
+					m.SetFieldValue("RetryBackoff")
+				} else {
+					m.state = failureThresholdView
+					m.SetFieldValue("FailureThreshold")
+				}
+			case "esc":
+				m.state = requestDelayView
+				m.SetFieldValue("RequestDelay")
+			}
+
+		case retryBackoffView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				backoff := strings.TrimSpace(m.textinput.Value())
+				if !validMilliseconds(backoff) {
+					m.errorMsg = "Invalid retry backoff (milliseconds)"
+					break
+				}
+				m.currService.RetryBackoff = backoff
+				m.state = failureThresholdView
+				m.SetFieldValue("FailureThreshold")
+			case "esc":
+				m.state = retriesView
+				m.SetFieldValue("Retries")
+			}
+
+		case failureThresholdView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				threshold := strings.TrimSpace(m.textinput.Value())
+				if !validThreshold(threshold) {
+					m.errorMsg = fmt.Sprintf("Invalid failure threshold (1-%d checks)", maxConfirmThreshold)
+					break
+				}
+				m.currService.FailureThreshold = threshold
+				m.state = recoveryThresholdView
+				m.SetFieldValue("RecoveryThreshold")
+			case "esc":
+				if m.currService.Type == typeComposite {
+					m.state = requestDelayView
+					m.SetFieldValue("RequestDelay")
+				} else if retryCount(m.currService.Retries) > 0 {
+					m.state = retryBackoffView
+					m.SetFieldValue("RetryBackoff")
+				} else {
+					m.state = retriesView
+					m.SetFieldValue("Retries")
+				}
+			}
+
+		case recoveryThresholdView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				threshold := strings.TrimSpace(m.textinput.Value())
+				if !validThreshold(threshold) {
+					m.errorMsg = fmt.Sprintf("Invalid recovery threshold (1-%d checks)", maxConfirmThreshold)
+					break
+				}
+				m.currService.RecoveryThreshold = threshold
+				// Composite services make no request to time
+				if m.currService.Type == typeComposite {
+					m.store.SaveService(m.currService)
+					m.state = listView
+					return m, m.reload
+				}
+				m.state = timeoutView
+				m.SetFieldValue("Timeout")
+			case "esc":
+				m.state = failureThresholdView
+				m.SetFieldValue("FailureThreshold")
+			}
+
+		case timeoutView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				timeout := strings.TrimSpace(m.textinput.Value())
+				if !validMilliseconds(timeout) {
+					m.errorMsg = "Invalid timeout (milliseconds)"
+					break
+				}
+				m.currService.Timeout = timeout
+				m.state = degradedThresholdView
+				m.SetFieldValue("DegradedThreshold")
+			case "esc":
+				m.state = recoveryThresholdView
+				m.SetFieldValue("RecoveryThreshold")
+			}
+
+		case degradedThresholdView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				threshold := strings.TrimSpace(m.textinput.Value())
+				if !validMilliseconds(threshold) {
+					m.errorMsg = "Invalid degraded threshold (milliseconds)"
+					break
+				}
+				m.currService.DegradedThreshold = threshold
+				m.state = downThresholdView
+				m.SetFieldValue("DownThreshold")
+			case "esc":
+				m.state = timeoutView
+				m.SetFieldValue("Timeout")
+			}
+
+		case downThresholdView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				threshold := strings.TrimSpace(m.textinput.Value())
+				if !validMilliseconds(threshold) {
+					m.errorMsg = "Invalid down threshold (milliseconds)"
+					break
+				}
+				if threshold != "" && m.currService.DegradedThreshold != "" &&
+					msDuration(threshold, 0) <= msDuration(m.currService.DegradedThreshold, 0) {
+					m.errorMsg = "Down threshold must be greater than the degraded threshold"
+					break
+				}
+				m.currService.DownThreshold = threshold
+				// TCP, ICMP and gRPC services have no response to assert on
+				if m.currService.Type == typeTCP || m.currService.Type == typeICMP || m.currService.Type == typeGRPC {
+					m.store.SaveService(m.currService)
+					m.state = listView
+					return m, m.reload
+				}
+				// Multistep services assert on each step
+				if m.currService.Type == typeMultiStep {
+					m.state = insecureSkipVerifyView
+					m.SetFieldValue("InsecureSkipVerify")
+					break
+				}
+				// DNS services only have dns assertions
+				if m.currService.Type == typeDNS {
+					m.state = assertionsView
+					m.SetFieldValue("Assertions")
+					break
+				}
+				m.state = jsonPropertyView
+				m.SetFieldValue("JSONProperty")
+			case "esc":
+				m.state = degradedThresholdView
+				m.SetFieldValue("DegradedThreshold")
+			}
+
+		case jsonPropertyView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				jsonProperty := strings.TrimSpace(m.textinput.Value())
+				if jsonProperty != "" && m.currService.bodilessMethod() {
+					m.errorMsg = m.currService.Method + " responses have no body to read a JSON property from"
+					break
+				}
+				if jsonProperty != "" {
+					if _, err := compileJSONPath(jsonProperty); err != nil {
+						m.errorMsg = "Invalid JSON property: " + err.Error()
+						break
+					}
+				}
+				m.currService.JSONProperty = jsonProperty
+				if jsonProperty != "" {
+					m.state = expectedValueView
+					m.SetFieldValue("ExpectedValue")
+				} else {
+					m.state = assertionsView
+					m.SetFieldValue("Assertions")
+				}
+			case "esc":
+				m.state = downThresholdView
+				m.SetFieldValue("DownThreshold")
+			}
+
+		case expectedValueView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				expectedValue := strings.TrimSpace(m.textinput.Value())
+				m.currService.ExpectedValue = expectedValue
+				m.state = assertionsView
+				m.SetFieldValue("Assertions")
+			case "esc":
+				m.state = jsonPropertyView
+				m.SetFieldValue("JSONProperty")
+			}
+
+		case assertionsView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				input := strings.TrimSpace(m.textinput.Value())
+				// An empty input finishes the assertions list
+				if input == "" && m.currService.Type == typeDNS {
+					m.store.SaveService(m.currService)
+					m.state = listView
+					return m, m.reload
+				}
+				// A successful handshake has a single status, skip asking for it
+				if input == "" && m.currService.Type == typeWebSocket {
+					m.state = insecureSkipVerifyView
+					m.SetFieldValue("InsecureSkipVerify")
+					break
+				}
+				if input == "" && m.currService.bodilessMethod() && m.currService.hasContentAssertions() {
+					m.errorMsg = m.currService.Method + " responses have no body, remove the body and json assertions"
+					break
+				}
+				if input == "" {
+					m.state = preferredStatusView
+					m.SetFieldValue("PreferredStatus")
+					break
+				}
+				// -N removes the Nth assertion
+				if n, err := strconv.Atoi(input); err == nil && n < 0 {
+					if -n > len(m.currService.Assertions) {
+						m.errorMsg = "No such assertion"
+						break
+					}
+					m.currService.Assertions = slices.Delete(slices.Clone(m.currService.Assertions), -n-1, -n)
+					m.textinput.SetValue("")
+					break
+				}
+				assertion, err := parseAssertion(input)
+				if err != nil {
+					m.errorMsg = "Invalid assertion: " + err.Error()
+					break
+				}
+				if !assertion.supports(m.currService.Type) {
+					m.errorMsg = "Invalid assertion: " + assertion.Source + " assertions don't apply to this service type"
+					break
+				}
+				if (assertion.Source == sourceBody || assertion.Source == sourceJSON) && m.currService.bodilessMethod() {
+					m.errorMsg = "Invalid assertion: " + m.currService.Method + " responses have no body"
+					break
+				}
+				m.currService.Assertions = append(slices.Clone(m.currService.Assertions), assertion)
+				m.textinput.SetValue("")
+			case "esc":
+				if m.currService.Type == typeDNS {
+					m.state = downThresholdView
+					m.SetFieldValue("DownThreshold")
+				} else if m.currService.JSONProperty != "" {
+					m.state = expectedValueView
+					m.SetFieldValue("ExpectedValue")
+				} else {
+					m.state = jsonPropertyView
+					m.SetFieldValue("JSONProperty")
+				}
+			}
+
+		case preferredStatusView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				preferredStatus := strings.TrimSpace(m.textinput.Value())
+				if preferredStatus == "" {
+					m.errorMsg = "Preferred status cannot be empty (100-599)"
+					break
+				}
+				if _, err := parseStatusCodes(preferredStatus); err != nil {
+					m.errorMsg = "Invalid preferred status (e.g. 200, 200,204, 2xx or 200-299)"
+					break
+				}
+				m.currService.PreferredStatus = preferredStatus
+				m.state = insecureSkipVerifyView
+				m.SetFieldValue("InsecureSkipVerify")
+			case "esc":
+				m.state = assertionsView
+				m.SetFieldValue("Assertions")
+			}
+
+		case insecureSkipVerifyView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				insecureSkipVerify := strings.TrimSpace(m.textinput.Value())
+				lower := strings.ToLower(insecureSkipVerify)
+				if lower != "true" && lower != "false" {
+					m.errorMsg = "Invalid insecure skip verify (true/false)"
+					break
+				}
+				m.currService.InsecureSkipVerify = lower
+				if m.currService.Type == typeGRPC {
+					m.state = headersView
+					m.SetFieldValue("Headers")
+					break
+				}
+				// Certificate thresholds only apply to HTTPS and WSS endpoints
+				secure := strings.HasPrefix(m.currService.Endpoint, "https://") || strings.HasPrefix(m.currService.Endpoint, "wss://")
+				for _, step := range m.currService.Steps {
+					secure = secure || strings.HasPrefix(step.Endpoint, "https://")
+				}
+				if secure {
+					m.state = certWarningDaysView
+					m.SetFieldValue("CertWarningDays")
+					break
+				}
+				m.store.SaveService(m.currService)
+				m.state = listView
+				return m, m.reload
+			case "esc":
+				if m.currService.Type == typeGRPC {
+					m.state = grpcTLSView
+					m.SetFieldValue("GRPCTLS")
+				} else if m.currService.Type == typeWebSocket {
+					m.state = assertionsView
+					m.SetFieldValue("Assertions")
+				} else if m.currService.Type == typeMultiStep {
+					m.state = downThresholdView
+					m.SetFieldValue("DownThreshold")
+				} else {
+					m.state = preferredStatusView
+					m.SetFieldValue("PreferredStatus")
+				}
+			}
+
+		case certWarningDaysView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				days := strings.TrimSpace(m.textinput.Value())
+				if !validDays(days) {
+					m.errorMsg = "Invalid certificate warning (days)"
+					break
+				}
+				m.currService.CertWarningDays = days
+				m.state = certCriticalDaysView
+				m.SetFieldValue("CertCriticalDays")
+			case "esc":
+				m.state = insecureSkipVerifyView
+				m.SetFieldValue("InsecureSkipVerify")
+			}
+
+		case certCriticalDaysView:
+			switch key {
+			case "enter":
+				m.errorMsg = ""
+				days := strings.TrimSpace(m.textinput.Value())
+				if !validDays(days) {
+					m.errorMsg = "Invalid certificate critical threshold (days)"
+					break
+				}
+				warning := daysValue(m.currService.CertWarningDays, defaultCertWarningDays)
+				if critical := daysValue(days, defaultCertCriticalDays); warning > 0 && critical >= warning {
+					m.errorMsg = "Certificate critical threshold must be lower than the warning threshold"
+					break
+				}
+				m.currService.CertCriticalDays = days
+				m.store.SaveService(m.currService)
+				m.state = listView
+				return m, m.reload
+			case "esc":
+				m.state = certWarningDaysView
+				m.SetFieldValue("CertWarningDays")
+			}
+		}
+	default:
+		m.spinner, cmd = m.spinner.Update(msg)
+		cmds = append(cmds, cmd)
+		m.pulseSpinner, cmd = m.pulseSpinner.Update(msg)
+		cmds = append(cmds, cmd)
+		return m, tea.Batch(cmds...)
+	}
+	return m, tea.Batch(cmds...)
+}
+
+// edit starts the wizard for the selected service
+func (m *model) edit() {
+	m.currService = m.services[m.listIndex]
+	m.state = nameView
+	m.textinput.SetValue(m.currService.Name)
+	m.textinput.Focus()
+	m.textinput.CursorEnd()
+}
+
+// loadDetail reads the history of the selected service for the detail view
+// in the background, returning it as a detailMsg
+func (m model) loadDetail() tea.Cmd {
+	s := m.services[m.listIndex]
+	store := m.store
+	return func() tea.Msg {
+		msg := detailMsg{id: s.ID}
+
+		history, err := store.GetHistory(s, detailHistoryLimit)
+		if err != nil {
+			msg.errorMsg = "Unable to load history: " + err.Error()
+			return msg
+		}
+		msg.detail.history = history
+
+		now := time.Now()
+		for i, w := range uptimeWindows {
+			uptime, checks, err := store.GetUptime(s, now.Add(-w.period))
+			if err != nil {
+				msg.errorMsg = "Unable to load uptime: " + err.Error()
+				return msg
+			}
+			if checks > 0 {
+				msg.detail.uptime[i] = &uptime
+			}
+		}
+		return msg
+	}
+}
+
+// reload restarts the scheduler with the stored services and refreshes the
+// list
+func (m model) reload() tea.Msg {
+	if err := m.scheduler.Reload(); err != nil {
+		log.Printf("Unable to reload scheduler: %v", err)
+	}
+	return dataMsg(refreshServices(m))
+}
+
+func refreshServices(m model) []Service {
+	services, err := m.store.GetServices()
+	if err != nil {
+		log.Fatalf("Unable to get services: %v", err)
+	}
+
+	for i := range services {
+		if len(services[i].StatusHistory) == 0 {
+			continue
+		}
+		services[i].LastStatusInfo = statusInfo(services[i].StatusHistory)
+	}
+
+	return dependencyTree(services)
+}
+
+// statusInfo renders the current status and the health bar of a status
+// history ordered newest first
+func statusInfo(history []Check) string {
+	if len(history) == 0 {
+		return ""
+	}
+
+	// Create status bar info
+	last := history[0]
+	statusBar := "- " + statusStyle(last.Status).Padding(0, 1).Render(statusLabel(last.Status))
+
+	// Health bar, oldest check first
+	for i := len(history) - 1; i >= 0; i-- {
+		statusBar += " " + statusStyle(history[i].Status).Render(" ")
+	}
+
+	statusBar += " " + faint.Render(checkSummary(last))
+
+	return statusBar
+}
+
+// checkSummary describes a check in a single line: its status code and
+// response time, followed by the failure reason if any
+func checkSummary(c Check) string {
+	parts := []string{}
+	if c.StatusCode != 0 {
+		parts = append(parts, strconv.Itoa(c.StatusCode))
+	}
+	if c.Latency > 0 {
+		parts = append(parts, c.Latency.Round(time.Millisecond).String())
+	}
+	if c.ReplyLatency > 0 {
+		parts = append(parts, "reply "+c.ReplyLatency.Round(time.Millisecond).String())
+	}
+	if !c.CertExpiry.IsZero() {
+		parts = append(parts, fmt.Sprintf("cert %dd", certDays(c.CertExpiry)))
+	}
+	if c.PacketsSent > 0 {
+		parts = append(parts, fmt.Sprintf("loss %d%%", c.PacketsLost*100/c.PacketsSent))
+	}
+	if len(c.Attempts) > 1 {
+		parts = append(parts, fmt.Sprintf("%d attempts", len(c.Attempts)))
+	}
+	if c.Observed != "" && c.Observed != c.Status {
+		parts = append(parts, "unconfirmed "+string(c.Observed))
+	}
+	if c.Category != "" {
+		reason := c.Error
+		if len(reason) > 60 {
+			reason = truncate(reason, 60) + "..."
+		}
+		parts = append(parts, c.Category+": "+reason)
+	}
+	return strings.Join(parts, " | ")
+}
+
+// validMilliseconds reports whether value is blank or a non negative integer
+func validMilliseconds(value string) bool {
+	if value == "" {
+		return true
+	}
+	ms, err := strconv.Atoi(value)
+	return err == nil && ms >= 0
+}
+
+// validDays reports whether value is blank or a non negative number of days
+func validDays(value string) bool {
+	if value == "" {
+		return true
+	}
+	days, err := strconv.Atoi(value)
+	return err == nil && days >= 0
+}
+
+func (m *model) SetFieldValue(p string) {
+	v := reflect.ValueOf(m.currService)
+	field := v.FieldByName(p)
+	var value string
+	if field.IsValid() && field.Kind() == reflect.String {
+		value = field.String()
+	}
+	if value != "" {
+		m.textinput.SetValue(value)
+	} else {
+		m.textinput.SetValue("")
+	}
+	if p == "AuthSecret" {
+		m.textinput.EchoMode = textinput.EchoPassword
+	} else {
+		m.textinput.EchoMode = textinput.EchoNormal
+	}
+	m.textinput.Focus()
+	m.textinput.CursorEnd()
+}
+
+// setStep replaces the step edited by the wizard. Steps are copied so the
+// service list isn't changed before the service is saved.
+func (m *model) setStep(step Step) {
+	steps := slices.Clone(m.currService.Steps)
+	steps[m.stepIndex] = step
+	m.currService.Steps = steps
+}
+
+// parseHeader parses a "Name: value" header line. An empty value is allowed
+// and is used to remove a header from the list.
+func parseHeader(line string) (Header, error) {
+	name, value, found := strings.Cut(line, ":")
+	name = strings.TrimSpace(name)
+	if !found || name == "" || strings.ContainsAny(name, " \t") {
+		return Header{}, errors.New("Invalid header (Name: value)")
+	}
+	return Header{Name: http.CanonicalHeaderKey(name), Value: strings.TrimSpace(value)}, nil
+}
+
+// setHeader adds or replaces a header by name, removing it when the value is
+// empty.
+func setHeader(headers []Header, header Header) []Header {
+	result := []Header{}
+	replaced := false
+	for _, h := range headers {
+		if !strings.EqualFold(h.Name, header.Name) {
+			result = append(result, h)
+			continue
+		}
+		if header.Value != "" && !replaced {
+			result = append(result, header)
+			replaced = true
+		}
+	}
+	if header.Value != "" && !replaced {
+		result = append(result, header)
 	}
-	return status && resp.StatusCode == int(preferredStatus)
+	return result
 }
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultTimeout  = 10 * time.Second
+	maxErrorMessage = 256
+	maxBodySize     = 10 << 20 // Bytes of a response body read for assertions
+
+	defaultCertWarningDays  = 14
+	defaultCertCriticalDays = 3
+)
+
+// Status is the health of a service as seen by a single check.
+type Status string
+
+const (
+	StatusOnline      Status = "online"
+	StatusDegraded    Status = "degraded" // Responding, but slower than expected
+	StatusOffline     Status = "offline"
+	StatusUnreachable Status = "unreachable" // Failing while a service it depends on is down
+)
+
+// Up reports whether the service answered the check as expected, even if slowly.
+func (st Status) Up() bool {
+	return st == StatusOnline || st == StatusDegraded
+}
+
+// Methods a request can be sent with
+var httpMethods = []string{"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS"}
+
+// Failure categories recorded with a check that didn't go as expected.
+const (
+	failureRequest    = "request" // The request couldn't be built from the service config
+	failureDNS        = "dns"
+	failureConnection = "connection"
+	failureTLS        = "tls"
+	// Certificate problems are told apart from other TLS failures
+	failureCertExpiry    = "cert_expiry"
+	failureCertHostname  = "cert_hostname"
+	failureCertUntrusted = "cert_untrusted"
+	failureTimeout       = "timeout"
+	failureStatusCode    = "status_code"
+	failureHeader        = "header"
+	failureJSON          = "json"
+	failureBody          = "body"
+	failureBanner        = "banner" // The TCP banner didn't match
+	failureAnswer        = "answer" // The DNS answer failed an assertion
+	failurePacketLoss    = "packet_loss"
+	failureGRPC          = "grpc"       // The health check call failed or the service isn't SERVING
+	failureReply         = "reply"      // No WebSocket message came back
+	failureExtract       = "extract"    // A value couldn't be extracted from a step response
+	failureMembers       = "members"    // Not enough members of a composite service are up
+	failureDependency    = "dependency" // A service this one depends on is down
+	failureSlow          = "slow"       // Slower than the degraded or down threshold
+)
+
+// Check is the outcome of a single check of a service.
+type Check struct {
+	Status     Status
+	Latency    time.Duration
+	StatusCode int
+	Category   string    // Failure category, blank when the check succeeded
+	Error      string    // Truncated error message
+	CertExpiry time.Time // Earliest expiry in the certificate chain, zero without TLS
+	// Echo requests of an ICMP check, zero for other types
+	PacketsSent int
+	PacketsLost int
+	// Wait for the first reply to a WebSocket message, Latency being the
+	// handshake
+	ReplyLatency time.Duration
+	Step         int // Failed step of a multistep check, 1-based, zero otherwise
+	// Status of the check itself, Status being the state of the service
+	// confirmed by enough consecutive checks
+	Observed Status
+	Attempts []Check // Tries of a retried check, oldest first, empty without retries
+	Time     time.Time
+}
+
+// fail marks the check offline with the given failure category and error
+func (c Check) fail(category string, err error) Check {
+	c.Status = StatusOffline
+	c.Category = category
+	c.Error = truncate(err.Error(), maxErrorMessage)
+	return c
+}
+
+// getStatus checks a service with the probe of its type
+func getStatus(ctx context.Context, s Service) Check {
+	switch s.Type {
+	case typeTCP:
+		return getTCPStatus(ctx, s)
+	case typeDNS:
+		return getDNSStatus(ctx, s)
+	case typeICMP:
+		return getICMPStatus(ctx, s)
+	case typeGRPC:
+		return getGRPCStatus(ctx, s)
+	case typeWebSocket:
+		return getWebSocketStatus(ctx, s)
+	case typeMultiStep:
+		return getMultiStepStatus(ctx, s)
+	default:
+		return getHTTPStatus(ctx, s)
+	}
+}
+
+func getHTTPStatus(ctx context.Context, s Service) Check {
+	check := Check{Time: time.Now()}
+
+	isv := s.InsecureSkipVerify == "true"
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: isv},
+	}
+	// The transport isn't reused, its keep-alive connections would pile up
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr, Timeout: msDuration(s.Timeout, defaultTimeout)}
+
+	// A blank or invalid preferred status falls back to 200
+	accepted, err := parseStatusCodes(s.PreferredStatus)
+	if err != nil {
+		accepted, _ = parseStatusCodes("200")
+	}
+	// Redirects are followed unless one is the expected answer
+	if accepted.acceptsRedirect() {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	req, err := newRequest(ctx, s)
+	if err != nil {
+		return check.fail(failureRequest, err)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	check.Latency = time.Since(start)
+	if err != nil {
+		// Certificates that failed verification still tell when they expire
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			check.CertExpiry = chainExpiry(certErr.UnverifiedCertificates)
+		}
+		return check.fail(requestError(err))
+	}
+
+	defer resp.Body.Close()
+	check.StatusCode = resp.StatusCode
+	if resp.TLS != nil {
+		chain := resp.TLS.PeerCertificates
+		if len(resp.TLS.VerifiedChains) > 0 {
+			chain = resp.TLS.VerifiedChains[0]
+		}
+		check.CertExpiry = chainExpiry(chain)
+	}
+
+	if !accepted.match(resp.StatusCode) {
+		return check.fail(failureStatusCode, fmt.Errorf("expected status %s, got %d", accepted, resp.StatusCode))
+	}
+
+	if err := s.evalHeaders(resp.Header); err != nil {
+		return check.fail(failureHeader, err)
+	}
+
+	// A json_property saved before it was refused for HEAD and OPTIONS has
+	// no body to decode
+	if s.hasContentAssertions() && !s.bodilessMethod() {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+		if err != nil {
+			return check.fail(classifyError(err), err)
+		}
+		if category, err := s.evalContent(body); err != nil {
+			return check.fail(category, err)
+		}
+	}
+
+	return gradeCertificate(s, gradeLatency(s, check))
+}
+
+// requestError returns the failure category of a failed HTTP request and
+// its reason. The URL is already known, so only the underlying reason is
+// kept.
+func requestError(err error) (string, error) {
+	category := classifyError(err)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return category, err
+}
+
+// evalHeaders runs the header assertions of a service against response
+// headers. Every failed assertion is reported, not only the first one.
+func (s Service) evalHeaders(header http.Header) error {
+	var errs []string
+	for _, a := range s.sourceAssertions(sourceHeader) {
+		if err := a.evalHeader(header); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// evalContent runs the body and json assertions of a service against a
+// response body or message, returning the failure category of the first
+// one that fails.
+func (s Service) evalContent(body []byte) (string, error) {
+	for _, a := range s.sourceAssertions(sourceBody) {
+		if err := a.evalBody(body); err != nil {
+			return failureBody, err
+		}
+	}
+
+	jsonAssertions := s.jsonAssertions()
+	if len(jsonAssertions) == 0 {
+		return "", nil
+	}
+	doc, err := decodeJSON(body)
+	if err != nil {
+		return failureJSON, fmt.Errorf("invalid JSON body: %w", err)
+	}
+	for _, a := range jsonAssertions {
+		if err := a.evalJSON(doc); err != nil {
+			return failureJSON, err
+		}
+	}
+	return "", nil
+}
+
+// hasContentAssertions reports whether the service asserts on the body or
+// JSON of its responses
+func (s Service) hasContentAssertions() bool {
+	return len(s.sourceAssertions(sourceBody))+len(s.jsonAssertions()) > 0
+}
+
+// bodilessMethod reports whether the service is sent with a method whose
+// responses carry no meaningful body to assert on
+func (s Service) bodilessMethod() bool {
+	method := strings.ToUpper(strings.TrimSpace(s.Method))
+	return method == http.MethodHead || method == http.MethodOptions
+}
+
+// gradeCertificate marks a check degraded or offline when the certificate
+// of the service expires within the warning or critical number of days.
+func gradeCertificate(s Service, check Check) Check {
+	if check.CertExpiry.IsZero() || check.Status == StatusOffline {
+		return check
+	}
+	days := certDays(check.CertExpiry)
+	if critical := daysValue(s.CertCriticalDays, defaultCertCriticalDays); critical > 0 && days < critical {
+		return check.fail(failureCertExpiry, fmt.Errorf("certificate expires in %d days, critical threshold is %d", days, critical))
+	}
+	// A slow response is already reported as degraded
+	if warning := daysValue(s.CertWarningDays, defaultCertWarningDays); warning > 0 && days < warning && check.Status == StatusOnline {
+		check.Status = StatusDegraded
+		check.Category = failureCertExpiry
+		check.Error = fmt.Sprintf("certificate expires in %d days, warning threshold is %d", days, warning)
+	}
+	return check
+}
+
+// chainExpiry returns the earliest expiry of a certificate chain, as an
+// intermediate can expire before the leaf.
+func chainExpiry(chain []*x509.Certificate) time.Time {
+	var expiry time.Time
+	for _, cert := range chain {
+		if expiry.IsZero() || cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+	return expiry
+}
+
+// certDays returns the number of whole days left before expiry, negative
+// once expired
+func certDays(expiry time.Time) int {
+	return int(math.Floor(time.Until(expiry).Hours() / 24))
+}
+
+// gradeLatency grades a successful check by its response time, including
+// the reply of a WebSocket message, against the degraded and down
+// thresholds of the service.
+func gradeLatency(s Service, check Check) Check {
+	latency := check.Latency + check.ReplyLatency
+	if down := msDuration(s.DownThreshold, 0); down > 0 && latency >= down {
+		return check.fail(failureSlow, fmt.Errorf("response took %v, down threshold is %v", latency.Round(time.Millisecond), down))
+	}
+	check.Status = StatusOnline
+	if degraded := msDuration(s.DegradedThreshold, 0); degraded > 0 && latency >= degraded {
+		check.Status = StatusDegraded
+		check.Category = failureSlow
+		check.Error = fmt.Sprintf("response took %v, degraded threshold is %v", latency.Round(time.Millisecond), degraded)
+	}
+	return check
+}
+
+// classifyError maps a request error to its failure category
+func classifyError(err error) string {
+	var (
+		dnsErr       *net.DNSError
+		netErr       net.Error
+		certErr      *tls.CertificateVerificationError
+		recordErr    tls.RecordHeaderError
+		hostnameErr  x509.HostnameError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return failureDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return failureTimeout
+	case errors.As(err, &hostnameErr):
+		return failureCertHostname
+	case errors.As(err, &authorityErr):
+		return failureCertUntrusted
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return failureCertExpiry
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &invalidErr):
+		return failureTLS
+	default:
+		return failureConnection
+	}
+}
+
+// truncate shortens s to at most n bytes without splitting a rune
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// statusCodes is a set of accepted HTTP status codes, as inclusive ranges
+type statusCodes [][2]int
+
+// parseStatusCodes reads a comma separated list of codes ("200,204"),
+// classes ("2xx") and ranges ("200-299").
+func parseStatusCodes(spec string) (statusCodes, error) {
+	codes := statusCodes{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+
+		var low, high int
+		var err error
+		switch from, to, isRange := strings.Cut(part, "-"); {
+		case isRange:
+			low, err = strconv.Atoi(strings.TrimSpace(from))
+			if err == nil {
+				high, err = strconv.Atoi(strings.TrimSpace(to))
+			}
+		case len(part) == 3 && strings.HasSuffix(part, "xx"):
+			low, err = strconv.Atoi(part[:1])
+			low *= 100
+			high = low + 99
+		default:
+			low, err = strconv.Atoi(part)
+			high = low
+		}
+		if err != nil || low < 100 || high > 599 || low > high {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		codes = append(codes, [2]int{low, high})
+	}
+	if len(codes) == 0 {
+		return nil, errors.New("no status codes")
+	}
+	return codes, nil
+}
+
+func (c statusCodes) match(code int) bool {
+	for _, r := range c {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsRedirect reports whether any 3xx code is accepted
+func (c statusCodes) acceptsRedirect() bool {
+	for _, r := range c {
+		if r[0] <= 399 && r[1] >= 300 {
+			return true
+		}
+	}
+	return false
+}
+
+func (c statusCodes) String() string {
+	parts := make([]string, 0, len(c))
+	for _, r := range c {
+		switch {
+		case r[0] == r[1]:
+			parts = append(parts, strconv.Itoa(r[0]))
+		case r[0]%100 == 0 && r[1] == r[0]+99:
+			parts = append(parts, fmt.Sprintf("%dxx", r[0]/100))
+		default:
+			parts = append(parts, fmt.Sprintf("%d-%d", r[0], r[1]))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// daysValue parses a days column value, falling back to def when it is
+// blank or invalid. Zero disables the threshold.
+func daysValue(value string, def int) int {
+	days, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || days < 0 {
+		return def
+	}
+	return days
+}
+
+// msDuration parses a milliseconds column value, falling back to def when it
+// is blank or invalid.
+func msDuration(value string, def time.Duration) time.Duration {
+	ms, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || ms <= 0 {
+		return def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// newRequest builds the HTTP request for a service using its configured
+// method, sending the payload as the request body when present. HEAD and
+// OPTIONS requests carry no body.
+func newRequest(ctx context.Context, s Service) (*http.Request, error) {
+	method := strings.ToUpper(strings.TrimSpace(s.Method))
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if s.Payload != "" && !s.bodilessMethod() {
+		body = strings.NewReader(s.Payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.Endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", payloadContentType(s.Payload))
+	}
+
+	switch s.AuthType {
+	case authBasic:
+		req.SetBasicAuth(s.AuthUsername, s.AuthSecret)
+	case authBearer:
+		req.Header.Set("Authorization", "Bearer "+s.AuthSecret)
+	case authAPIKey:
+		header := s.AuthHeader
+		if header == "" {
+			header = defaultAPIKeyHeader
+		}
+		req.Header.Set(header, s.AuthSecret)
+	}
+
+	// Custom headers go last so they can override the detected Content-Type
+	// and the auth header
+	for _, h := range s.Headers {
+		if strings.EqualFold(h.Name, "Host") {
+			req.Host = h.Value
+			continue
+		}
+		req.Header.Set(h.Name, h.Value)
+	}
+	return req, nil
+}
+
+// payloadContentType guesses the Content-Type of a payload: JSON documents
+// are sent as application/json, url encoded pairs as a form and anything
+// else as plain text.
+func payloadContentType(payload string) string {
+	if json.Valid([]byte(payload)) {
+		return "application/json"
+	}
+	if !strings.ContainsAny(payload, " \n\t") && strings.Contains(payload, "=") {
+		if _, err := url.ParseQuery(payload); err == nil {
+			return "application/x-www-form-urlencoded"
+		}
+	}
+	return "text/plain; charset=utf-8"
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseStatusCodes(t *testing.T) {
+	tests := []struct {
+		spec     string
+		want     string // Canonical form
+		match    []int
+		noMatch  []int
+		redirect bool
+	}{
+		{spec: "200", want: "200", match: []int{200}, noMatch: []int{201, 204}},
+		{spec: "200,204", want: "200,204", match: []int{200, 204}, noMatch: []int{201, 404}},
+		{spec: " 200 , 204 ,", want: "200,204", match: []int{200, 204}},
+		{spec: "2xx", want: "2xx", match: []int{200, 250, 299}, noMatch: []int{199, 300}},
+		{spec: "2XX", want: "2xx", match: []int{204}},
+		{spec: "200-299", want: "2xx", match: []int{200, 299}, noMatch: []int{300}},
+		{spec: "200-204", want: "200-204", match: []int{200, 204}, noMatch: []int{205}},
+		{spec: "200 - 204", want: "200-204", match: []int{202}},
+		{spec: "2xx,301", want: "2xx,301", match: []int{201, 301}, noMatch: []int{302}, redirect: true},
+		{spec: "350-450", want: "350-450", match: []int{399, 404}, redirect: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			codes, err := parseStatusCodes(tt.spec)
+			if err != nil {
+				t.Fatalf("parseStatusCodes(%q): %v", tt.spec, err)
+			}
+			if got := codes.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+			for _, code := range tt.match {
+				if !codes.match(code) {
+					t.Errorf("%q doesn't match %d", tt.spec, code)
+				}
+			}
+			for _, code := range tt.noMatch {
+				if codes.match(code) {
+					t.Errorf("%q matches %d", tt.spec, code)
+				}
+			}
+			if got := codes.acceptsRedirect(); got != tt.redirect {
+				t.Errorf("acceptsRedirect() = %v, want %v", got, tt.redirect)
+			}
+		})
+	}
+}
+
+func TestParseStatusCodesErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		" , ",
+		"20x",
+		"x00",
+		"2x",
+		"6xx",
+		"0xx",
+		"600",
+		"99",
+		"ok",
+		"300-200",
+		"200-",
+		"-299",
+		"200-600",
+		"200,abc",
+	} {
+		if codes, err := parseStatusCodes(spec); err == nil {
+			t.Errorf("parseStatusCodes(%q) = %s, want an error", spec, codes)
+		}
+	}
+}
+
+// newCertServer starts a TLS server with a self-signed certificate for the
+// given names, or for 127.0.0.1 without any, expiring after validFor
+func newCertServer(t *testing.T, validFor time.Duration, names ...string) *httptest.Server {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     names,
+	}
+	if len(names) == 0 {
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	// Rejected handshakes are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCertificateStatus(t *testing.T) {
+	const day = 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		validFor time.Duration
+		names    []string // Blank for a certificate matching 127.0.0.1
+		verify   bool
+		warning  string
+		critical string
+		want     Status
+		category string
+	}{
+		{name: "far from expiry", validFor: 60 * day, want: StatusOnline},
+		{name: "within warning", validFor: 10 * day, want: StatusDegraded, category: failureCertExpiry},
+		{name: "within critical", validFor: 2 * day, want: StatusOffline, category: failureCertExpiry},
+		{name: "custom warning", validFor: 30 * day, warning: "45", critical: "7", want: StatusDegraded, category: failureCertExpiry},
+		{name: "custom critical", validFor: 30 * day, warning: "90", critical: "45", want: StatusOffline, category: failureCertExpiry},
+		{name: "grading disabled", validFor: 2 * day, warning: "0", critical: "0", want: StatusOnline},
+		{name: "expired unverified", validFor: -time.Hour, want: StatusOffline, category: failureCertExpiry},
+		{name: "expired", validFor: -time.Hour, verify: true, want: StatusOffline, category: failureCertExpiry},
+		{name: "untrusted", validFor: 60 * day, verify: true, want: StatusOffline, category: failureCertUntrusted},
+		{name: "hostname mismatch", validFor: 60 * day, names: []string{"other.test"}, verify: true, want: StatusOffline, category: failureCertHostname},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newCertServer(t, tt.validFor, tt.names...)
+			s := Service{
+				Method:             "GET",
+				Endpoint:           server.URL,
+				PreferredStatus:    "200",
+				InsecureSkipVerify: "true",
+				CertWarningDays:    tt.warning,
+				CertCriticalDays:   tt.critical,
+			}
+			if tt.verify {
+				s.InsecureSkipVerify = "false"
+			}
+
+			check := getHTTPStatus(context.Background(), s)
+			if check.Status != tt.want || check.Category != tt.category {
+				t.Fatalf("got %s (%s: %s), want %s (%s)", check.Status, check.Category, check.Error, tt.want, tt.category)
+			}
+			// The expiry is known whether the certificate was verified or not
+			if want := server.Certificate().NotAfter; !check.CertExpiry.Equal(want) {
+				t.Errorf("certificate expiry = %v, want %v", check.CertExpiry, want)
+			}
+		})
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	tests := []struct {
+		method      string
+		payload     string
+		headers     []Header
+		wantMethod  string
+		wantBody    string
+		contentType string
+	}{
+		{method: "", wantMethod: "GET"},
+		{method: "get", wantMethod: "GET"},
+		{method: "POST", payload: `{"query": "{ health }"}`, wantMethod: "POST", wantBody: `{"query": "{ health }"}`, contentType: "application/json"},
+		{method: "PUT", payload: "a=1&b=2", wantMethod: "PUT", wantBody: "a=1&b=2", contentType: "application/x-www-form-urlencoded"},
+		{method: "PATCH", payload: "ping", wantMethod: "PATCH", wantBody: "ping", contentType: "text/plain; charset=utf-8"},
+		{method: "POST", payload: `<ping/>`, headers: []Header{{Name: "Content-Type", Value: "application/xml"}}, wantMethod: "POST", wantBody: "<ping/>", contentType: "application/xml"},
+		{method: "DELETE", wantMethod: "DELETE"},
+		{method: "HEAD", payload: `{"ignored": true}`, wantMethod: "HEAD"},
+		{method: "OPTIONS", payload: `{"ignored": true}`, wantMethod: "OPTIONS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantMethod+" "+tt.payload, func(t *testing.T) {
+			s := Service{Method: tt.method, Endpoint: "https://api.test/health", Payload: tt.payload, Headers: tt.headers}
+			req, err := newRequest(context.Background(), s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Method != tt.wantMethod {
+				t.Errorf("method = %s, want %s", req.Method, tt.wantMethod)
+			}
+			body := ""
+			if req.Body != nil {
+				b, err := io.ReadAll(req.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(b)
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if got := req.Header.Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestPayloadContentType(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+	}{
+		{`{"a": 1}`, "application/json"},
+		{`[1, 2]`, "application/json"},
+		{`42`, "application/json"},
+		{`a=1&b=two`, "application/x-www-form-urlencoded"},
+		{`token=abc%20def`, "application/x-www-form-urlencoded"},
+		{`a = 1`, "text/plain; charset=utf-8"},
+		{`a=%zz`, "text/plain; charset=utf-8"},
+		{`{"a": 1`, "text/plain; charset=utf-8"},
+		{`ping`, "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		if got := payloadContentType(tt.payload); got != tt.want {
+			t.Errorf("payloadContentType(%q) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestBodilessMethods(t *testing.T) {
+	// Answers with a body that isn't JSON, and fails when sent one
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b, _ := io.ReadAll(r.Body); len(b) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("not json"))
+	}))
+	defer server.Close()
+
+	for _, method := range []string{"HEAD", "OPTIONS"} {
+		t.Run(method, func(t *testing.T) {
+			// A json_property stored before it was refused for these methods
+			s := Service{Method: method, Endpoint: server.URL, Payload: `{"a": 1}`, PreferredStatus: "200", JSONProperty: "$.status"}
+			if check := getHTTPStatus(context.Background(), s); check.Status != StatusOnline {
+				t.Errorf("got %s (%s: %s), want online", check.Status, check.Category, check.Error)
+			}
+		})
+	}
+
+	// The same service with GET decodes the body
+	s := Service{Method: "GET", Endpoint: server.URL, PreferredStatus: "200", JSONProperty: "$.status"}
+	if check := getHTTPStatus(context.Background(), s); check.Category != failureJSON {
+		t.Errorf("GET got %s (%s: %s), want a json failure", check.Status, check.Category, check.Error)
+	}
+}
+
+func TestRequestAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		service Service
+		header  string
+		want    string
+	}{
+		{
+			name:    "basic",
+			service: Service{AuthType: authBasic, AuthUsername: "admin", AuthSecret: "s3cret"},
+			header:  "Authorization",
+			want:    "Basic YWRtaW46czNjcmV0",
+		},
+		{
+			name:    "bearer",
+			service: Service{AuthType: authBearer, AuthSecret: "t0k3n"},
+			header:  "Authorization",
+			want:    "Bearer t0k3n",
+		},
+		{
+			name:    "apikey default header",
+			service: Service{AuthType: authAPIKey, AuthSecret: "k3y"},
+			header:  defaultAPIKeyHeader,
+			want:    "k3y",
+		},
+		{
+			name:    "apikey custom header",
+			service: Service{AuthType: authAPIKey, AuthHeader: "X-Auth-Token", AuthSecret: "k3y"},
+			header:  "X-Auth-Token",
+			want:    "k3y",
+		},
+		{
+			name:    "none",
+			service: Service{AuthType: authNone, AuthSecret: "unused"},
+			header:  "Authorization",
+		},
+		{
+			name:    "overridden by a custom header",
+			service: Service{AuthType: authBearer, AuthSecret: "t0k3n", Headers: []Header{{Name: "authorization", Value: "Token custom"}}},
+			header:  "Authorization",
+			want:    "Token custom",
+		},
+		{
+			name:    "apikey overridden by a custom header",
+			service: Service{AuthType: authAPIKey, AuthSecret: "k3y", Headers: []Header{{Name: defaultAPIKeyHeader, Value: "other"}}},
+			header:  defaultAPIKeyHeader,
+			want:    "other",
+		},
+		{
+			name:    "custom headers",
+			service: Service{AuthType: authBasic, AuthUsername: "admin", AuthSecret: "s3cret", Headers: []Header{{Name: "Accept", Value: "application/json"}}},
+			header:  "Accept",
+			want:    "application/json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.service.Endpoint = "https://api.test/health"
+			req, err := newRequest(context.Background(), tt.service)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get(tt.header); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+
+	// The Host header sets the host of the request rather than a header
+	req, err := newRequest(context.Background(), Service{Endpoint: "https://10.0.0.1/health", Headers: []Header{{Name: "Host", Value: "api.test"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Host != "api.test" || req.Header.Get("Host") != "" {
+		t.Errorf("request host = %q with header %q, want api.test", req.Host, req.Header.Get("Host"))
+	}
+}
+
+func TestGradeLatency(t *testing.T) {
+	const ms = time.Millisecond
+
+	tests := []struct {
+		latency  time.Duration
+		reply    time.Duration // WebSocket reply, added to the latency
+		degraded string
+		down     string
+		want     Status
+	}{
+		{latency: 5 * time.Second, want: StatusOnline},
+		{latency: 299 * ms, degraded: "300", down: "1000", want: StatusOnline},
+		{latency: 300 * ms, degraded: "300", down: "1000", want: StatusDegraded},
+		{latency: 999 * ms, degraded: "300", down: "1000", want: StatusDegraded},
+		{latency: 1000 * ms, degraded: "300", down: "1000", want: StatusOffline},
+		{latency: 200 * ms, reply: 100 * ms, degraded: "300", want: StatusDegraded},
+		{latency: 600 * ms, reply: 400 * ms, degraded: "300", down: "1000", want: StatusOffline},
+		{latency: 2 * time.Second, degraded: "300", want: StatusDegraded},
+		{latency: 2 * time.Second, down: "1000", want: StatusOffline},
+		{latency: 999 * ms, down: "1000", want: StatusOnline},
+		{latency: 2 * time.Second, degraded: "0", down: "0", want: StatusOnline},
+	}
+	for _, tt := range tests {
+		s := Service{DegradedThreshold: tt.degraded, DownThreshold: tt.down}
+		check := gradeLatency(s, Check{Latency: tt.latency, ReplyLatency: tt.reply})
+		if check.Status != tt.want {
+			t.Errorf("gradeLatency(%v + %v, degraded %q, down %q) = %s, want %s", tt.latency, tt.reply, tt.degraded, tt.down, check.Status, tt.want)
+		}
+		category := ""
+		if tt.want != StatusOnline {
+			category = failureSlow
+		}
+		if check.Category != category {
+			t.Errorf("gradeLatency(%v + %v, degraded %q, down %q) category = %q, want %q", tt.latency, tt.reply, tt.degraded, tt.down, check.Category, category)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxRetries          = 10
+	defaultRetryBackoff = 500 * time.Millisecond
+	// Consecutive checks needed to change the confirmed state of a service
+	defaultFailureThreshold  = 1
+	defaultRecoveryThreshold = 1
+	maxConfirmThreshold      = 100
+)
+
+// attempt runs the check of a service, retrying it when it fails. Retries
+// wait for the backoff, doubled after each one up to the check interval,
+// without holding a worker. The tries are kept in the returned check when
+// there was more than one. A cancelled context returns a zero check.
+func (sc *Scheduler) attempt(ctx context.Context, s Service) Check {
+	retries := retryCount(s.Retries)
+	backoff := msDuration(s.RetryBackoff, defaultRetryBackoff)
+	interval := checkInterval(s)
+
+	attempts := []Check{}
+	for try := 0; ; try++ {
+		select {
+		case sc.workers <- struct{}{}:
+		case <-ctx.Done():
+			return Check{}
+		}
+		check := getStatus(ctx, s)
+		<-sc.workers
+
+		attempts = append(attempts, check)
+		if check.Status.Up() || try >= retries || ctx.Err() != nil {
+			if len(attempts) > 1 {
+				check.Attempts = attempts
+			}
+			return check
+		}
+
+		select {
+		case <-time.After(retryDelay(backoff, try, interval)):
+		case <-ctx.Done():
+			return Check{}
+		}
+	}
+}
+
+// retryDelay returns the backoff doubled once per previous retry, capped at
+// limit so it can't overflow
+func retryDelay(backoff time.Duration, try int, limit time.Duration) time.Duration {
+	delay := min(backoff, limit)
+	for range try {
+		if delay >= limit/2 {
+			return limit
+		}
+		delay *= 2
+	}
+	return delay
+}
+
+// confirmation is the state of a service once confirmed by enough
+// consecutive checks, a single failed check doesn't take it offline.
+type confirmation struct {
+	status    Status // Blank until the first check
+	failures  int    // Consecutive down checks while up
+	successes int    // Consecutive up checks while down
+}
+
+// confirm records a check and returns it with the confirmed status. The
+// status of the check itself is kept as Observed. Changes between online and
+// degraded, or offline and unreachable, need no confirmation.
+func (c *confirmation) confirm(s Service, check Check) Check {
+	check.Observed = check.Status
+	if c.status == "" || c.status.Up() == check.Status.Up() {
+		c.status = check.Status
+		c.failures, c.successes = 0, 0
+		return check
+	}
+
+	needed := confirmThreshold(s.RecoveryThreshold, defaultRecoveryThreshold)
+	count := &c.successes
+	if !check.Status.Up() {
+		needed = confirmThreshold(s.FailureThreshold, defaultFailureThreshold)
+		count = &c.failures
+	}
+	*count++
+	if *count >= needed {
+		c.status = check.Status
+		c.failures, c.successes = 0, 0
+		return check
+	}
+	check.Status = c.status
+	return check
+}
+
+// retryCount returns the immediate retries of a failed check
+func retryCount(value string) int {
+	retries, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || retries < 0 {
+		return 0
+	}
+	return min(retries, maxRetries)
+}
+
+// confirmThreshold parses a number of consecutive checks, falling back to
+// def when it is blank or invalid
+func confirmThreshold(value string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return min(n, maxConfirmThreshold)
+}
+
+// validRetries reports whether value is blank or a valid number of retries
+func validRetries(value string) bool {
+	if value == "" {
+		return true
+	}
+	retries, err := strconv.Atoi(value)
+	return err == nil && retries >= 0 && retries <= maxRetries
+}
+
+// validThreshold reports whether value is blank or a valid number of
+// consecutive checks
+func validThreshold(value string) bool {
+	if value == "" {
+		return true
+	}
+	n, err := strconv.Atoi(value)
+	return err == nil && n > 0 && n <= maxConfirmThreshold
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		try     int
+		limit   time.Duration
+		want    time.Duration
+	}{
+		{500 * time.Millisecond, 0, 5 * time.Second, 500 * time.Millisecond},
+		{500 * time.Millisecond, 1, 5 * time.Second, time.Second},
+		{500 * time.Millisecond, 3, 5 * time.Second, 4 * time.Second},
+		{500 * time.Millisecond, 4, 5 * time.Second, 5 * time.Second},
+		{time.Minute, 0, 5 * time.Second, 5 * time.Second},
+		{time.Duration(math.MaxInt64 / 2), maxRetries, time.Hour, time.Hour},
+		{time.Duration(math.MaxInt64 / 4), maxRetries, time.Duration(math.MaxInt64), time.Duration(math.MaxInt64)},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.backoff, tt.try, tt.limit); got != tt.want {
+			t.Errorf("retryDelay(%v, %d, %v) = %v, want %v", tt.backoff, tt.try, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	const (
+		on  = StatusOnline
+		deg = StatusDegraded
+		off = StatusOffline
+		unr = StatusUnreachable
+	)
+	tests := []struct {
+		name     string
+		failure  string
+		recovery string
+		start    Status // Last stored state, blank for a new service
+		checks   []Status
+		want     []Status // Confirmed state after each check
+	}{
+		{
+			name:   "default thresholds",
+			checks: []Status{on, off, on, deg},
+			want:   []Status{on, off, on, deg},
+		},
+		{
+			name:    "failure threshold",
+			failure: "3",
+			checks:  []Status{on, off, off, off, off},
+			want:    []Status{on, on, on, off, off},
+		},
+		{
+			name:    "failure count reset by a success",
+			failure: "2",
+			checks:  []Status{on, off, on, off, deg, off, off},
+			want:    []Status{on, on, on, on, deg, deg, off},
+		},
+		{
+			name:     "recovery threshold",
+			recovery: "2",
+			checks:   []Status{off, on, on, on},
+			want:     []Status{off, off, on, on},
+		},
+		{
+			name:     "recovery count reset by a failure",
+			recovery: "3",
+			checks:   []Status{off, on, deg, off, on, on, on},
+			want:     []Status{off, off, off, off, off, off, on},
+		},
+		{
+			name:     "changes within up or down",
+			failure:  "3",
+			recovery: "3",
+			checks:   []Status{on, deg, on, off, off, off, unr, off},
+			want:     []Status{on, deg, on, on, on, off, unr, off},
+		},
+		{
+			name:    "stored state",
+			failure: "2",
+			start:   off,
+			checks:  []Status{off, on},
+			want:    []Status{off, on},
+		},
+		{
+			name:     "stored state needs recovery",
+			recovery: "2",
+			start:    off,
+			checks:   []Status{on, on},
+			want:     []Status{off, on},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Service{FailureThreshold: tt.failure, RecoveryThreshold: tt.recovery}
+			conf := &confirmation{status: tt.start}
+			for i, status := range tt.checks {
+				check := conf.confirm(s, Check{Status: status})
+				if check.Status != tt.want[i] || check.Observed != status {
+					t.Fatalf("check %d (%s) confirmed as %s observed %s, want %s observed %s", i, status, check.Status, check.Observed, tt.want[i], status)
+				}
+			}
+		})
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  string
+		failures int32 // Requests answered with an error before a success
+		want     Status
+		attempts int // Tries kept in the check, 0 without retries
+	}{
+		{name: "no retries", retries: "", failures: 1, want: StatusOffline},
+		{name: "passes first", retries: "3", failures: 0, want: StatusOnline},
+		{name: "passes on retry", retries: "3", failures: 2, want: StatusOnline, attempts: 3},
+		{name: "retries exhausted", retries: "2", failures: 5, want: StatusOffline, attempts: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+
+			sc := NewScheduler(nil, 1)
+			s := Service{Endpoint: server.URL, PreferredStatus: "200", Retries: tt.retries, RetryBackoff: "1"}
+			check := sc.attempt(context.Background(), s)
+			if check.Status != tt.want {
+				t.Errorf("status = %s (%s), want %s", check.Status, check.Error, tt.want)
+			}
+			if len(check.Attempts) != tt.attempts {
+				t.Fatalf("got %d attempts, want %d", len(check.Attempts), tt.attempts)
+			}
+			for i, a := range check.Attempts {
+				want := StatusOffline
+				if int32(i) >= tt.failures {
+					want = StatusOnline
+				}
+				if a.Status != want {
+					t.Errorf("attempt %d is %s, want %s", i+1, a.Status, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAttemptCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	// The backoff is long enough that the check is cancelled while waiting
+	sc := NewScheduler(nil, 1)
+	s := Service{Endpoint: server.URL, PreferredStatus: "200", Retries: "3", RetryBackoff: "10000", RequestDelay: "10000"}
+	start := time.Now()
+	check := sc.attempt(ctx, s)
+	if !check.Time.IsZero() {
+		t.Errorf("got %s check, want a zero check", check.Status)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled attempt took %v", elapsed)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCheckInterval = 5 * time.Second
+	defaultWorkers       = 8
+	historyRetention     = 30 * 24 * time.Hour
+	historyPruneInterval = time.Hour
+)
+
+// Result is a check of a service made by the scheduler.
+type Result struct {
+	Service Service
+	Check
+}
+
+// Scheduler runs every service on its own timer, checking it at its own
+// interval. Checks are capped by a fixed size worker pool so a large number
+// of services can't flood the network. Results are saved to the store and
+// then handed to every subscribed listener. History older than the
+// retention is pruned in the background.
+type Scheduler struct {
+	store     *Store
+	workers   chan struct{}
+	listeners []func(Result)
+	removed   []func(id string)
+
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   map[string]*job
+	last   map[string]Check // Latest check of each running service
+	wg     sync.WaitGroup
+}
+
+// job is a running service loop
+type job struct {
+	service Service
+	cancel  context.CancelFunc
+}
+
+func NewScheduler(store *Store, workers int) *Scheduler {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	return &Scheduler{
+		store:   store,
+		workers: make(chan struct{}, workers),
+		jobs:    map[string]*job{},
+		last:    map[string]Check{},
+	}
+}
+
+// Subscribe registers a listener called after every check. Listeners must be
+// registered before Start.
+func (sc *Scheduler) Subscribe(fn func(Result)) {
+	sc.listeners = append(sc.listeners, fn)
+}
+
+// SubscribeRemoved registers a listener called with the ID of every service
+// that stopped being checked because it was deleted. Listeners must be
+// registered before Start.
+func (sc *Scheduler) SubscribeRemoved(fn func(id string)) {
+	sc.removed = append(sc.removed, fn)
+}
+
+// Start loads the services from the store and starts checking them until
+// ctx is cancelled or Stop is called.
+func (sc *Scheduler) Start(ctx context.Context) error {
+	sc.mu.Lock()
+	sc.ctx, sc.cancel = context.WithCancel(ctx)
+	sc.wg.Add(1)
+	go sc.prune(sc.ctx)
+	sc.mu.Unlock()
+	return sc.Reload()
+}
+
+// Reload syncs the running loops with the services in the store: loops of
+// deleted services are stopped, new services are started and services whose
+// configuration changed are restarted.
+func (sc *Scheduler) Reload() error {
+	services, err := sc.store.GetServices()
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	if sc.ctx == nil || sc.ctx.Err() != nil {
+		sc.mu.Unlock()
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, s := range services {
+		seen[s.ID] = true
+		if j, ok := sc.jobs[s.ID]; ok {
+			if sameConfig(j.service, s) {
+				continue
+			}
+			j.cancel()
+		}
+
+		ctx, cancel := context.WithCancel(sc.ctx)
+		sc.jobs[s.ID] = &job{service: s, cancel: cancel}
+		sc.wg.Add(1)
+		go sc.run(ctx, s)
+	}
+
+	removed := []string{}
+	for id, j := range sc.jobs {
+		if !seen[id] {
+			j.cancel()
+			delete(sc.jobs, id)
+			delete(sc.last, id)
+			removed = append(removed, id)
+		}
+	}
+	sc.mu.Unlock()
+
+	for _, id := range removed {
+		for _, fn := range sc.removed {
+			fn(id)
+		}
+	}
+	return nil
+}
+
+// Reset restarts the loop of a service so it starts over from its stored
+// history, e.g. once that history was cleared.
+func (sc *Scheduler) Reset(id string) error {
+	sc.mu.Lock()
+	if j, ok := sc.jobs[id]; ok {
+		j.cancel()
+		delete(sc.jobs, id)
+		delete(sc.last, id)
+	}
+	sc.mu.Unlock()
+	return sc.Reload()
+}
+
+// Stop cancels every service loop and waits for in-flight checks to finish.
+func (sc *Scheduler) Stop() {
+	sc.mu.Lock()
+	if sc.cancel != nil {
+		sc.cancel()
+	}
+	for id, j := range sc.jobs {
+		j.cancel()
+		delete(sc.jobs, id)
+	}
+	sc.mu.Unlock()
+	sc.wg.Wait()
+}
+
+func (sc *Scheduler) run(ctx context.Context, s Service) {
+	defer sc.wg.Done()
+
+	// The first check runs right away, except for composite services which
+	// give their members time to be checked first
+	var first time.Duration
+	if s.Type == typeComposite {
+		first = checkInterval(s)
+	}
+	timer := time.NewTimer(first)
+	defer timer.Stop()
+
+	// Consecutive checks are counted from the last stored state
+	conf := &confirmation{}
+	if len(s.StatusHistory) > 0 {
+		conf.status = s.StatusHistory[0].Status
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		sc.check(ctx, s, conf)
+		timer.Reset(checkInterval(s))
+	}
+}
+
+func (sc *Scheduler) check(ctx context.Context, s Service, conf *confirmation) {
+	var check Check
+	if s.Type == typeComposite {
+		// Composite services make no request, they read the last checks of
+		// their members
+		check = getCompositeStatus(s, sc.latestChecks(s.Members))
+	} else {
+		check = sc.attempt(ctx, s)
+	}
+
+	// A check cancelled by a reload or shutdown says nothing about the service
+	if ctx.Err() != nil {
+		return
+	}
+
+	// A failure while a service this one depends on is down is blamed on it
+	if !check.Status.Up() && len(s.Parents) > 0 {
+		check = unreachable(check, sc.latestChecks(s.Parents))
+	}
+	check = conf.confirm(s, check)
+
+	sc.mu.Lock()
+	sc.last[s.ID] = check
+	sc.mu.Unlock()
+
+	result := Result{Service: s, Check: check}
+
+	if err := sc.store.SaveHistory(s, check); err != nil {
+		log.Printf("Failed to save history for service %s: %v", s.ID, err)
+	}
+
+	for _, fn := range sc.listeners {
+		fn(result)
+	}
+}
+
+// latestChecks returns the running services among ids with their latest
+// check, which is the last stored one until they are checked again
+func (sc *Scheduler) latestChecks(ids []string) []serviceCheck {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	checks := []serviceCheck{}
+	for _, id := range ids {
+		j, ok := sc.jobs[id]
+		if !ok {
+			continue
+		}
+		c := serviceCheck{Name: j.service.Name}
+		if check, ok := sc.last[id]; ok {
+			c.Check = check
+		} else if len(j.service.StatusHistory) > 0 {
+			c.Check = j.service.StatusHistory[0]
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// prune deletes the history older than the retention right away and then
+// every prune interval, until ctx is cancelled.
+func (sc *Scheduler) prune(ctx context.Context) {
+	defer sc.wg.Done()
+
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for {
+		if err := sc.store.PruneHistory(time.Now().Add(-historyRetention)); err != nil {
+			log.Printf("Failed to prune history: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkInterval returns the time between two checks of a service, taken from
+// its request delay in milliseconds.
+func checkInterval(s Service) time.Duration {
+	return msDuration(s.RequestDelay, defaultCheckInterval)
+}
+
+// sameConfig reports whether two services share the same configuration,
+// ignoring the status values that aren't stored as columns.
+func sameConfig(a, b Service) bool {
+	a.LastStatusInfo, b.LastStatusInfo = "", ""
+	a.StatusHistory, b.StatusHistory = nil, nil
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Accepted statuses of a step without a preferred status
+const defaultStepStatus = "2xx"
+
+// Step is a request of a multistep service. Values extracted from its
+// response can be used by the following steps as {{name}} in their
+// endpoint, headers and payload. The endpoint of a multistep service is the
+// one of its first step, for display and alerts.
+type Step struct {
+	Name            string // Optional, shown in errors
+	Method          string
+	Endpoint        string
+	Payload         string
+	PreferredStatus string // Accepted statuses, blank for any 2xx
+	Headers         []Header
+	Extractions     []Extraction
+	Assertions      []Assertion
+}
+
+// Extraction sources, the part of a step response a value is taken from
+const (
+	extractJSON   = "json"   // A JSONPath in the body, its first match
+	extractHeader = "header" // A response header
+	extractRegex  = "regex"  // The first capture group of a regex on the body, or the whole match
+)
+
+// Extraction saves a value of a step response in a variable
+type Extraction struct {
+	Name   string
+	Source string
+	Target string // JSONPath, header name or regex
+}
+
+// Variables are referenced as {{name}}
+var stepVariable = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// getMultiStepStatus runs the steps of a service in order, sharing cookies
+// and extracted values between them. The check fails at the first failing
+// step, which is recorded with it. The timeout covers all the steps.
+func getMultiStepStatus(ctx context.Context, s Service) Check {
+	check := Check{Time: time.Now()}
+	if len(s.Steps) == 0 {
+		return check.fail(failureRequest, errors.New("no steps to run"))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, msDuration(s.Timeout, defaultTimeout))
+	defer cancel()
+
+	// Cookies set by a step are sent by the next ones, like in a browser
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return check.fail(failureRequest, err)
+	}
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify == "true"},
+	}
+	// Connections are kept between steps but not once the check is over
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr, Jar: jar}
+
+	vars := map[string]string{}
+	start := time.Now()
+	for i, step := range s.Steps {
+		category, err := runStep(ctx, client, step, vars, &check)
+		if err != nil {
+			check.Latency = time.Since(start)
+			check.Step = i + 1
+			return check.fail(category, fmt.Errorf("step %s: %w", step.label(i), err))
+		}
+	}
+	check.Latency = time.Since(start)
+
+	return gradeCertificate(s, gradeLatency(s, check))
+}
+
+// runStep sends the request of a step and checks its response, saving the
+// extracted values in vars. It returns the failure category and error of a
+// failed step.
+func runStep(ctx context.Context, client *http.Client, step Step, vars map[string]string, check *Check) (string, error) {
+	// The step is sent as a service of its own to share the request building
+	rs := Service{Method: step.Method, Headers: []Header{}}
+	var err error
+	if rs.Endpoint, err = expandVariables(step.Endpoint, vars); err != nil {
+		return failureRequest, err
+	}
+	if rs.Payload, err = expandVariables(step.Payload, vars); err != nil {
+		return failureRequest, err
+	}
+	for _, h := range step.Headers {
+		value, err := expandVariables(h.Value, vars)
+		if err != nil {
+			return failureRequest, err
+		}
+		rs.Headers = append(rs.Headers, Header{Name: h.Name, Value: value})
+	}
+	req, err := newRequest(ctx, rs)
+	if err != nil {
+		return failureRequest, err
+	}
+
+	accepted, err := parseStatusCodes(orDefault(step.PreferredStatus, defaultStepStatus))
+	if err != nil {
+		return failureRequest, err
+	}
+	client.CheckRedirect = nil
+	if accepted.acceptsRedirect() {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			check.CertExpiry = chainExpiry(certErr.UnverifiedCertificates)
+		}
+		return requestError(err)
+	}
+	defer resp.Body.Close()
+
+	check.StatusCode = resp.StatusCode
+	// The earliest expiry of all the steps is kept
+	if resp.TLS != nil {
+		chain := resp.TLS.PeerCertificates
+		if len(resp.TLS.VerifiedChains) > 0 {
+			chain = resp.TLS.VerifiedChains[0]
+		}
+		if expiry := chainExpiry(chain); check.CertExpiry.IsZero() || expiry.Before(check.CertExpiry) {
+			check.CertExpiry = expiry
+		}
+	}
+
+	if !accepted.match(resp.StatusCode) {
+		return failureStatusCode, fmt.Errorf("expected status %s, got %d", accepted, resp.StatusCode)
+	}
+
+	assertions := Service{Assertions: step.Assertions}
+	if err := assertions.evalHeaders(resp.Header); err != nil {
+		return failureHeader, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return classifyError(err), err
+	}
+	if category, err := assertions.evalContent(body); err != nil {
+		return category, err
+	}
+
+	for _, e := range step.Extractions {
+		value, err := e.extract(resp.Header, body)
+		if err != nil {
+			return failureExtract, fmt.Errorf("%s: %w", e.Name, err)
+		}
+		vars[e.Name] = value
+	}
+	return "", nil
+}
+
+// extract takes the value of an extraction from a step response
+func (e Extraction) extract(header http.Header, body []byte) (string, error) {
+	switch e.Source {
+	case extractHeader:
+		value := header.Get(e.Target)
+		if value == "" {
+			return "", fmt.Errorf("header %s missing", e.Target)
+		}
+		return value, nil
+
+	case extractRegex:
+		re, err := regexp.Compile(e.Target)
+		if err != nil {
+			return "", err
+		}
+		match := re.FindSubmatch(body)
+		if match == nil {
+			return "", fmt.Errorf("body doesn't match %s", e.Target)
+		}
+		if len(match) > 1 {
+			return string(match[1]), nil
+		}
+		return string(match[0]), nil
+
+	case extractJSON:
+		path, err := compileJSONPath(e.Target)
+		if err != nil {
+			return "", err
+		}
+		doc, err := decodeJSON(body)
+		if err != nil {
+			return "", fmt.Errorf("invalid JSON body: %w", err)
+		}
+		nodes := path.eval(doc)
+		if len(nodes) == 0 {
+			return "", fmt.Errorf("%s not found", e.Target)
+		}
+		return textOf(nodes[0]), nil
+	}
+	return "", fmt.Errorf("invalid extraction source %q", e.Source)
+}
+
+// expandVariables replaces the {{name}} references in text with the values
+// extracted by previous steps
+func expandVariables(text string, vars map[string]string) (string, error) {
+	var missing string
+	expanded := stepVariable.ReplaceAllStringFunc(text, func(ref string) string {
+		name := stepVariable.FindStringSubmatch(ref)[1]
+		value, ok := vars[name]
+		if !ok && missing == "" {
+			missing = name
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("undefined variable %s", missing)
+	}
+	return expanded, nil
+}
+
+// parseStep parses a step written as "[name] METHOD endpoint [statuses]",
+// e.g. "login POST https://example.com/login 200,201".
+func parseStep(line string) (Step, error) {
+	var step Step
+	fields := strings.Fields(line)
+	if len(fields) > 0 && !slices.Contains(httpMethods, strings.ToUpper(fields[0])) {
+		step.Name, fields = fields[0], fields[1:]
+	}
+	if len(fields) < 2 || len(fields) > 3 {
+		return step, errors.New("expected [name] METHOD endpoint [statuses]")
+	}
+	step.Method = strings.ToUpper(fields[0])
+	step.Endpoint = fields[1]
+	if len(fields) == 3 {
+		step.PreferredStatus = fields[2]
+	}
+	step.Headers = []Header{}
+	step.Extractions = []Extraction{}
+	step.Assertions = []Assertion{}
+	return step, step.validate()
+}
+
+// validate checks the request settings of a step
+func (step Step) validate() error {
+	if step.Name != "" && !variableName.MatchString(step.Name) {
+		return fmt.Errorf("invalid step name %q (letters, digits and _)", step.Name)
+	}
+	if !slices.Contains(httpMethods, step.Method) {
+		return fmt.Errorf("invalid method %q (%s)", step.Method, strings.Join(httpMethods, ", "))
+	}
+	if !strings.HasPrefix(step.Endpoint, "http://") && !strings.HasPrefix(step.Endpoint, "https://") {
+		return fmt.Errorf("invalid endpoint %q (http:// or https://)", step.Endpoint)
+	}
+	if step.PreferredStatus != "" {
+		if _, err := parseStatusCodes(step.PreferredStatus); err != nil {
+			return fmt.Errorf("invalid status %q (e.g. 200, 200,204, 2xx or 200-299)", step.PreferredStatus)
+		}
+	}
+	return nil
+}
+
+// String renders a step the way parseStep reads it
+func (step Step) String() string {
+	parts := []string{}
+	if step.Name != "" {
+		parts = append(parts, step.Name)
+	}
+	parts = append(parts, step.Method, step.Endpoint)
+	if step.PreferredStatus != "" {
+		parts = append(parts, step.PreferredStatus)
+	}
+	return strings.Join(parts, " ")
+}
+
+// label names the ith step in errors
+func (step Step) label(i int) string {
+	if step.Name == "" {
+		return fmt.Sprint(i + 1)
+	}
+	return fmt.Sprintf("%d (%s)", i+1, step.Name)
+}
+
+// parseExtraction parses an extraction written as "name = source target",
+// e.g. "token = json $.access_token", "session = header X-Session" or
+// "csrf = regex name=\"csrf\" value=\"([^\"]+)\"".
+func parseExtraction(line string) (Extraction, error) {
+	var e Extraction
+	name, rest, found := strings.Cut(line, "=")
+	e.Name = strings.TrimSpace(name)
+	if !found || !variableName.MatchString(e.Name) {
+		return e, errors.New("expected name = source target")
+	}
+	source, target, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	e.Source = strings.ToLower(source)
+	e.Target = strings.TrimSpace(target)
+	if e.Target == "" {
+		return e, fmt.Errorf("extraction %s needs a target", e.Name)
+	}
+
+	switch e.Source {
+	case extractJSON:
+		if _, err := compileJSONPath(e.Target); err != nil {
+			return e, err
+		}
+	case extractHeader:
+		if strings.ContainsAny(e.Target, " :") {
+			return e, fmt.Errorf("invalid header name %q", e.Target)
+		}
+		e.Target = http.CanonicalHeaderKey(e.Target)
+	case extractRegex:
+		if _, err := regexp.Compile(e.Target); err != nil {
+			return e, fmt.Errorf("invalid regex: %w", err)
+		}
+	default:
+		return e, fmt.Errorf("invalid extraction source %q (json, header, regex)", source)
+	}
+	return e, nil
+}
+
+func (e Extraction) String() string {
+	return e.Name + " = " + e.Source + " " + e.Target
+}
+
+// validateSteps checks the steps of a service, and that every variable is
+// extracted by an earlier step than the ones using it.
+func validateSteps(steps []Step) error {
+	if len(steps) == 0 {
+		return errors.New("at least one step is required")
+	}
+	defined := map[string]bool{}
+	for i, step := range steps {
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("step %s: %w", step.label(i), err)
+		}
+		texts := []string{step.Endpoint, step.Payload}
+		for _, h := range step.Headers {
+			if _, err := parseHeader(h.Name + ": " + h.Value); err != nil {
+				return fmt.Errorf("step %s: invalid header %q", step.label(i), h.Name)
+			}
+			texts = append(texts, h.Value)
+		}
+		for _, text := range texts {
+			for _, ref := range stepVariable.FindAllStringSubmatch(text, -1) {
+				if !defined[ref[1]] {
+					return fmt.Errorf("step %s: variable %s isn't extracted by a previous step", step.label(i), ref[1])
+				}
+			}
+		}
+		for _, a := range step.Assertions {
+			if !a.supports(typeHTTP) {
+				return fmt.Errorf("step %s: %s assertions don't apply to HTTP requests", step.label(i), a.Source)
+			}
+		}
+		for _, e := range step.Extractions {
+			defined[e.Name] = true
+		}
+	}
+	return nil
+}
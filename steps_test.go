@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newStep builds a step from its parseStep line followed by its extraction
+// and assertion lines, told apart by the "=" of extractions
+func newStep(t *testing.T, line string, headers []Header, rules ...string) Step {
+	t.Helper()
+	step, err := parseStep(line)
+	if err != nil {
+		t.Fatalf("parseStep(%q): %v", line, err)
+	}
+	step.Headers = headers
+	for _, rule := range rules {
+		if strings.Contains(rule, " = ") {
+			e, err := parseExtraction(rule)
+			if err != nil {
+				t.Fatalf("parseExtraction(%q): %v", rule, err)
+			}
+			step.Extractions = append(step.Extractions, e)
+			continue
+		}
+		a, err := parseAssertion(rule)
+		if err != nil {
+			t.Fatalf("parseAssertion(%q): %v", rule, err)
+		}
+		step.Assertions = append(step.Assertions, a)
+	}
+	return step
+}
+
+func TestMultiStepStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "c00kie", Path: "/"})
+		w.Header().Set("X-Request-Id", "req-1")
+		fmt.Fprint(w, `{"token": "t0k3n", "user": {"id": 42}}`)
+	})
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil || cookie.Value != "c00kie" {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t0k3n" || r.Header.Get("X-Request-Id") != "req-1" {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `<p>Welcome user %s</p>`, r.PathValue("id"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	login := newStep(t, "login POST "+server.URL+"/login", []Header{},
+		"token = json $.token", "id = json $.user.id", "request = header X-Request-Id")
+	profile := newStep(t, "profile GET "+server.URL+"/users/{{id}}",
+		[]Header{{Name: "Authorization", Value: "Bearer {{token}}"}, {Name: "X-Request-Id", Value: "{{request}}"}},
+		"body contains Welcome user 42", "user = regex user (\\d+)")
+
+	tests := []struct {
+		name     string
+		steps    []Step
+		want     Status
+		category string
+		step     int
+		err      string // Part of the error
+	}{
+		{name: "extracted values and cookies", steps: []Step{login, profile}, want: StatusOnline},
+		{
+			name:  "no cookie",
+			steps: []Step{newStep(t, "GET "+server.URL+"/users/42", []Header{{Name: "Authorization", Value: "Bearer t0k3n"}})},
+			want:  StatusOffline, category: failureStatusCode, step: 1, err: "step 1: expected status 2xx, got 403",
+		},
+		{
+			name:  "failed assertion",
+			steps: []Step{login, newStep(t, "GET "+server.URL+"/users/{{id}}", profile.Headers, "body contains admin")},
+			want:  StatusOffline, category: failureBody, step: 2, err: "step 2: ",
+		},
+		{
+			name:  "missing value",
+			steps: []Step{login, newStep(t, "check GET "+server.URL+"/users/{{id}}", profile.Headers, "role = json $.role")},
+			want:  StatusOffline, category: failureExtract, step: 2, err: "step 2 (check): role: ",
+		},
+		{
+			name:  "undefined variable",
+			steps: []Step{login, profile, newStep(t, "GET "+server.URL+"/users/{{missing}}", []Header{})},
+			want:  StatusOffline, category: failureRequest, step: 3, err: "undefined variable missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Service{ID: "flow", Name: "flow", Type: typeMultiStep, Steps: tt.steps}
+			check := getMultiStepStatus(context.Background(), s)
+			if check.Status != tt.want || check.Category != tt.category || check.Step != tt.step {
+				t.Fatalf("got %s (%s at step %d: %s), want %s (%s at step %d)", check.Status, check.Category, check.Step, check.Error, tt.want, tt.category, tt.step)
+			}
+			if !strings.Contains(check.Error, tt.err) {
+				t.Errorf("error = %q, want %q", check.Error, tt.err)
+			}
+		})
+	}
+}
+
+func TestMultiStepHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	t.Chdir(t.TempDir())
+	store := new(Store)
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.conn.Close()
+
+	s := Service{Name: "flow", Type: typeMultiStep, Endpoint: server.URL, InsecureSkipVerify: "false", AuthType: authNone, Steps: []Step{
+		newStep(t, "GET "+server.URL+"/", []Header{}),
+		newStep(t, "broken GET "+server.URL+"/broken", []Header{}),
+	}}
+	if err := store.SaveService(s); err != nil {
+		t.Fatal(err)
+	}
+	services, err := store.GetServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = services[0]
+
+	if err := store.SaveHistory(s, getMultiStepStatus(context.Background(), s)); err != nil {
+		t.Fatal(err)
+	}
+	history, err := store.GetHistory(s, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 {
+		t.Fatalf("got %d checks, want 1", len(history))
+	}
+	if got := history[0]; got.Status != StatusOffline || got.Step != 2 || got.StatusCode != 500 || !strings.HasPrefix(got.Error, "step 2 (broken): ") {
+		t.Errorf("stored check is %s at step %d with status %d: %s, want offline at step 2 with status 500", got.Status, got.Step, got.StatusCode, got.Error)
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"os"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -13,6 +15,7 @@ import (
 type Service struct {
 	ID                 string
 	Name               string
+	Type               string // http, tcp, dns, icmp, grpc, websocket, multistep, composite
 	Method             string
 	Endpoint           string
 	Payload            string
@@ -21,66 +24,102 @@ type Service struct {
 	ExpectedValue      string
 	PreferredStatus    string
 	InsecureSkipVerify string // Boolean (Y, N)
+	AuthType           string // none, basic, bearer, apikey
+	AuthUsername       string
+	AuthSecret         string // Password, token or API key
+	AuthHeader         string // Header carrying the API key
+	Timeout            string // Milliseconds
+	DegradedThreshold  string // Milliseconds
+	DownThreshold      string // Milliseconds
+	Managed            string // Boolean (true, false), defined by the config file
+	Banner             string // Regex the banner of a TCP service must match
+	Resolver           string // DNS server queried by a DNS service, host:port
+	RecordType         string // A, AAAA, CNAME, MX, TXT
+	PingCount          string // Echo requests sent by each check of an ICMP service
+	GRPCService        string // Service name sent in gRPC health checks, blank for the whole server
+	GRPCTLS            string // Boolean (true, false), connect to a gRPC service over TLS
+	Rule               string // all, any or the number of members of a composite service that must be up
+	Retries            string // Immediate retries of a failed check
+	RetryBackoff       string // Milliseconds before the first retry, doubled after each one
+	FailureThreshold   string // Consecutive failed checks to mark the service offline
+	RecoveryThreshold  string // Consecutive passed checks to mark the service up again
+	CertWarningDays    string // Days before certificate expiry to mark the service degraded
+	CertCriticalDays   string // Days before certificate expiry to mark the service offline
 	// Non column values
+	Headers        []Header
+	Assertions     []Assertion
+	Steps          []Step   // Requests of a multistep service, in order
+	Members        []string // IDs of the members of a composite service
+	Parents        []string // IDs of the services this one depends on
 	LastStatusInfo string
-	StatusHistory  []bool
+	StatusHistory  []Check // Newest first
 }
 
+// Header is a custom request header sent with every check of a service.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Supported service types for Service.Type. Services saved before types
+// existed have none and are HTTP.
+const (
+	typeHTTP      = "http"
+	typeTCP       = "tcp"
+	typeDNS       = "dns"
+	typeICMP      = "icmp"
+	typeGRPC      = "grpc"
+	typeWebSocket = "websocket"
+	typeMultiStep = "multistep"
+	typeComposite = "composite"
+)
+
+// Supported authentication modes for Service.AuthType.
+const (
+	authNone   = "none"
+	authBasic  = "basic"
+	authBearer = "bearer"
+	authAPIKey = "apikey"
+)
+
+const defaultAPIKeyHeader = "X-API-Key"
+
+const serviceColumns = `id, name, method, endpoint, payload, request_delay, json_property, expected_value, preferred_status, insecure_skip_verify, auth_type, auth_username, auth_secret, auth_header, timeout, degraded_threshold, down_threshold, managed, cert_warning_days, cert_critical_days, service_type, banner, resolver, record_type, ping_count, grpc_service, grpc_tls, rule, retries, retry_backoff, failure_threshold, recovery_threshold`
+
 type Store struct {
 	conn *sql.DB
 }
 
+const (
+	dbPath = "./goardian.db"
+	// Left behind by versions that rebuilt the database on every launch
+	legacyBackupPath = "./goardian.bak.db"
+	// A database without tables, set aside when recovering the legacy backup
+	partialRestorePath = "./goardian.partial.db"
+)
+
 func (s *Store) Init() error {
 	var err error
 
-	// Check if goardian.db exists and backup if needed
-	if err := s.backupExistingDatabase(); err != nil {
-		return fmt.Errorf("failed to backup existing database: %w", err)
+	if err := recoverLegacyBackup(); err != nil {
+		return fmt.Errorf("failed to recover backup database: %w", err)
 	}
 
-	s.conn, err = sql.Open("sqlite", "./goardian.db")
+	// Checks run concurrently, so writers wait for the lock instead of failing
+	s.conn, err = sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return err
 	}
 
-	createTableStmt := `CREATE TABLE IF NOT EXISTS services (
-		id text not null primary key,
-		name text not null,
-		method text not null,
-		endpoint text not null,
-		payload text not null,
-		request_delay text not null,
-		json_property text null,
-		expected_value text null,
-		preferred_status text null,
-		insecure_skip_verify text null
-	);`
-
-	if _, err := s.conn.Exec(createTableStmt); err != nil {
-		return err
-	}
-	createHistoryTableStmt := `CREATE TABLE IF NOT EXISTS history (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		service_id TEXT NOT NULL,
-		status BOOLEAN NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY(service_id) REFERENCES services(id)
-	);`
-
-	if _, err := s.conn.Exec(createHistoryTableStmt); err != nil {
-		return err
-	}
-
-	// Restore data from backup if it exists
-	if err := s.restoreFromBackup(); err != nil {
-		return fmt.Errorf("failed to restore data from backup: %w", err)
+	if err := s.migrate(); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	return nil
 }
 
 func (s *Store) GetServices() ([]Service, error) {
-	rows, err := s.conn.Query("SELECT * FROM services")
+	rows, err := s.conn.Query("SELECT " + serviceColumns + " FROM services")
 	if err != nil {
 		return nil, err
 	}
@@ -89,172 +128,584 @@ func (s *Store) GetServices() ([]Service, error) {
 	defer rows.Close()
 	for rows.Next() {
 		service := Service{}
-		rows.Scan(&service.ID, &service.Name, &service.Method, &service.Endpoint, &service.Payload, &service.RequestDelay, &service.JSONProperty, &service.ExpectedValue, &service.PreferredStatus, &service.InsecureSkipVerify)
+		var authType, authUsername, authSecret, authHeader, timeout, degradedThreshold, downThreshold, managed, certWarningDays, certCriticalDays, serviceType, banner, resolver, recordType, pingCount, grpcService, grpcTLS, rule, retries, retryBackoff, failureThreshold, recoveryThreshold sql.NullString
+		if err := rows.Scan(&service.ID, &service.Name, &service.Method, &service.Endpoint, &service.Payload, &service.RequestDelay, &service.JSONProperty, &service.ExpectedValue, &service.PreferredStatus, &service.InsecureSkipVerify, &authType, &authUsername, &authSecret, &authHeader, &timeout, &degradedThreshold, &downThreshold, &managed, &certWarningDays, &certCriticalDays, &serviceType, &banner, &resolver, &recordType, &pingCount, &grpcService, &grpcTLS, &rule, &retries, &retryBackoff, &failureThreshold, &recoveryThreshold); err != nil {
+			return nil, err
+		}
+		service.AuthType = authType.String
+		service.AuthUsername = authUsername.String
+		service.AuthSecret = authSecret.String
+		service.AuthHeader = authHeader.String
+		service.Timeout = timeout.String
+		service.DegradedThreshold = degradedThreshold.String
+		service.DownThreshold = downThreshold.String
+		service.Managed = managed.String
+		service.CertWarningDays = certWarningDays.String
+		service.CertCriticalDays = certCriticalDays.String
+		service.Type = serviceType.String
+		service.Banner = banner.String
+		service.Resolver = resolver.String
+		service.RecordType = recordType.String
+		service.PingCount = pingCount.String
+		service.GRPCService = grpcService.String
+		service.GRPCTLS = grpcTLS.String
+		service.Rule = rule.String
+		service.Retries = retries.String
+		service.RetryBackoff = retryBackoff.String
+		service.FailureThreshold = failureThreshold.String
+		service.RecoveryThreshold = recoveryThreshold.String
 		services = append(services, service)
-		for i := range services {
-			historyRows, err := s.conn.Query(
-				"SELECT status FROM history WHERE service_id = ? ORDER BY timestamp DESC LIMIT 20",
-				services[i].ID,
-			)
-			if err == nil {
-				defer historyRows.Close()
-				services[i].StatusHistory = []bool{}
-				for historyRows.Next() {
-					var status bool
-					if err := historyRows.Scan(&status); err == nil {
-						services[i].StatusHistory = append(services[i].StatusHistory, status)
-					}
-				}
-			}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range services {
+		headers, err := s.GetHeaders(services[i])
+		if err != nil {
+			return nil, err
+		}
+		services[i].Headers = headers
+
+		assertions, err := s.GetAssertions(services[i])
+		if err != nil {
+			return nil, err
+		}
+		services[i].Assertions = assertions
+
+		steps, err := s.GetSteps(services[i])
+		if err != nil {
+			return nil, err
+		}
+		services[i].Steps = steps
+
+		members, err := s.GetMembers(services[i])
+		if err != nil {
+			return nil, err
+		}
+		services[i].Members = members
+
+		parents, err := s.GetParents(services[i])
+		if err != nil {
+			return nil, err
 		}
+		services[i].Parents = parents
 
+		history, err := s.GetHistory(services[i], 20)
+		if err != nil {
+			return nil, err
+		}
+		services[i].StatusHistory = history
 	}
 
 	return services, nil
 }
 
+func (s *Store) GetHeaders(service Service) ([]Header, error) {
+	rows, err := s.conn.Query("SELECT name, value FROM headers WHERE service_id = ? AND step IS NULL ORDER BY id", service.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	headers := []Header{}
+	for rows.Next() {
+		var h Header
+		if err := rows.Scan(&h.Name, &h.Value); err != nil {
+			return nil, err
+		}
+		headers = append(headers, h)
+	}
+	return headers, rows.Err()
+}
+
+func (s *Store) GetAssertions(service Service) ([]Assertion, error) {
+	rows, err := s.conn.Query("SELECT source, target, operator, value FROM assertions WHERE service_id = ? AND step IS NULL ORDER BY id", service.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assertions := []Assertion{}
+	for rows.Next() {
+		var a Assertion
+		if err := rows.Scan(&a.Source, &a.Target, &a.Operator, &a.Value); err != nil {
+			return nil, err
+		}
+		assertions = append(assertions, a)
+	}
+	return assertions, rows.Err()
+}
+
+// GetSteps returns the steps of a multistep service with their headers,
+// extractions and assertions, which are stored along the service ones with
+// the position of their step.
+func (s *Store) GetSteps(service Service) ([]Step, error) {
+	rows, err := s.conn.Query("SELECT name, method, endpoint, payload, preferred_status FROM steps WHERE service_id = ? ORDER BY position", service.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := []Step{}
+	for rows.Next() {
+		step := Step{Headers: []Header{}, Extractions: []Extraction{}, Assertions: []Assertion{}}
+		if err := rows.Scan(&step.Name, &step.Method, &step.Endpoint, &step.Payload, &step.PreferredStatus); err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return steps, nil
+	}
+
+	// Rows of a step that no longer exists are ignored
+	valid := func(step int) bool { return step >= 0 && step < len(steps) }
+
+	headerRows, err := s.conn.Query("SELECT step, name, value FROM headers WHERE service_id = ? AND step IS NOT NULL ORDER BY id", service.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer headerRows.Close()
+	for headerRows.Next() {
+		var step int
+		var h Header
+		if err := headerRows.Scan(&step, &h.Name, &h.Value); err != nil {
+			return nil, err
+		}
+		if valid(step) {
+			steps[step].Headers = append(steps[step].Headers, h)
+		}
+	}
+	if err := headerRows.Err(); err != nil {
+		return nil, err
+	}
+
+	extractionRows, err := s.conn.Query("SELECT step, name, source, target FROM extractions WHERE service_id = ? ORDER BY id", service.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer extractionRows.Close()
+	for extractionRows.Next() {
+		var step int
+		var e Extraction
+		if err := extractionRows.Scan(&step, &e.Name, &e.Source, &e.Target); err != nil {
+			return nil, err
+		}
+		if valid(step) {
+			steps[step].Extractions = append(steps[step].Extractions, e)
+		}
+	}
+	if err := extractionRows.Err(); err != nil {
+		return nil, err
+	}
+
+	assertionRows, err := s.conn.Query("SELECT step, source, target, operator, value FROM assertions WHERE service_id = ? AND step IS NOT NULL ORDER BY id", service.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer assertionRows.Close()
+	for assertionRows.Next() {
+		var step int
+		var a Assertion
+		if err := assertionRows.Scan(&step, &a.Source, &a.Target, &a.Operator, &a.Value); err != nil {
+			return nil, err
+		}
+		if valid(step) {
+			steps[step].Assertions = append(steps[step].Assertions, a)
+		}
+	}
+	return steps, assertionRows.Err()
+}
+
+// GetMembers returns the IDs of the members of a composite service
+func (s *Store) GetMembers(service Service) ([]string, error) {
+	rows, err := s.conn.Query("SELECT member_id FROM members WHERE service_id = ? ORDER BY id", service.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		members = append(members, id)
+	}
+	return members, rows.Err()
+}
+
+// GetParents returns the IDs of the services a service depends on
+func (s *Store) GetParents(service Service) ([]string, error) {
+	rows, err := s.conn.Query("SELECT parent_id FROM dependencies WHERE service_id = ? ORDER BY id", service.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parents := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		parents = append(parents, id)
+	}
+	return parents, rows.Err()
+}
+
 func (s *Store) SaveService(service Service) error {
 	if service.ID == "" {
 		id := uuid.New()
 		service.ID = id.String()
 	}
 
-	upsertQuery := `INSERT INTO services (id, name, method, endpoint, payload, request_delay, json_property, expected_value, preferred_status, insecure_skip_verify)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(id) DO UPDATE
-	SET name=excluded.name, method=excluded.method, endpoint=excluded.endpoint, payload=excluded.payload, request_delay=excluded.request_delay, json_property=excluded.json_property, expected_value=excluded.expected_value, preferred_status=excluded.preferred_status, insecure_skip_verify=excluded.insecure_skip_verify;`
-
-	if _, err := s.conn.Exec(upsertQuery, service.ID, service.Name, service.Method, service.Endpoint, service.Payload, service.RequestDelay, service.JSONProperty, service.ExpectedValue, service.PreferredStatus, service.InsecureSkipVerify); err != nil {
+	tx, err := s.conn.Begin()
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	if err := saveService(tx, service); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (s *Store) SaveHistory(service Service, status bool) error {
-	insertQuery := `INSERT INTO history (service_id, status) VALUES (?, ?);`
-	if _, err := s.conn.Exec(insertQuery, service.ID, status); err != nil {
+// saveService writes a service with an ID and everything that belongs to it
+// within tx
+func saveService(tx *sql.Tx, service Service) error {
+	upsertQuery := `INSERT INTO services (` + serviceColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE
+	SET name=excluded.name, method=excluded.method, endpoint=excluded.endpoint, payload=excluded.payload, request_delay=excluded.request_delay, json_property=excluded.json_property, expected_value=excluded.expected_value, preferred_status=excluded.preferred_status, insecure_skip_verify=excluded.insecure_skip_verify, auth_type=excluded.auth_type, auth_username=excluded.auth_username, auth_secret=excluded.auth_secret, auth_header=excluded.auth_header, timeout=excluded.timeout, degraded_threshold=excluded.degraded_threshold, down_threshold=excluded.down_threshold, managed=excluded.managed, cert_warning_days=excluded.cert_warning_days, cert_critical_days=excluded.cert_critical_days, service_type=excluded.service_type, banner=excluded.banner, resolver=excluded.resolver, record_type=excluded.record_type, ping_count=excluded.ping_count, grpc_service=excluded.grpc_service, grpc_tls=excluded.grpc_tls, rule=excluded.rule, retries=excluded.retries, retry_backoff=excluded.retry_backoff, failure_threshold=excluded.failure_threshold, recovery_threshold=excluded.recovery_threshold;`
+
+	if _, err := tx.Exec(upsertQuery, service.ID, service.Name, service.Method, service.Endpoint, service.Payload, service.RequestDelay, service.JSONProperty, service.ExpectedValue, service.PreferredStatus, service.InsecureSkipVerify, service.AuthType, service.AuthUsername, service.AuthSecret, service.AuthHeader, service.Timeout, service.DegradedThreshold, service.DownThreshold, service.Managed, service.CertWarningDays, service.CertCriticalDays, service.Type, service.Banner, service.Resolver, service.RecordType, service.PingCount, service.GRPCService, service.GRPCTLS, service.Rule, service.Retries, service.RetryBackoff, service.FailureThreshold, service.RecoveryThreshold); err != nil {
 		return err
 	}
-	return nil
-}
 
-func (s *Store) DeleteAllHistory(service Service) error {
-	deleteQuery := `DELETE FROM history WHERE service_id = ?;`
-	if _, err := s.conn.Exec(deleteQuery, service.ID); err != nil {
+	// Headers are replaced as a whole on every save
+	if _, err := tx.Exec(`DELETE FROM headers WHERE service_id = ?;`, service.ID); err != nil {
 		return err
 	}
-	return nil
-}
+	for _, h := range service.Headers {
+		if _, err := tx.Exec(`INSERT INTO headers (service_id, name, value) VALUES (?, ?, ?);`, service.ID, h.Name, h.Value); err != nil {
+			return err
+		}
+	}
 
-func (s *Store) DeleteService(service Service) error {
-	if service.ID == "" {
-		// if not exists throw an error
-		err := fmt.Errorf("could not find service %v with ID: %v", service.Name, service.ID)
+	// So are assertions
+	if _, err := tx.Exec(`DELETE FROM assertions WHERE service_id = ?;`, service.ID); err != nil {
 		return err
 	}
+	for _, a := range service.Assertions {
+		if _, err := tx.Exec(`INSERT INTO assertions (service_id, source, target, operator, value) VALUES (?, ?, ?, ?, ?);`, service.ID, a.Source, a.Target, a.Operator, a.Value); err != nil {
+			return err
+		}
+	}
 
-	// Delete service
-	deleteQuery := `DELETE FROM services WHERE id = ?;`
-	if _, err := s.conn.Exec(deleteQuery, service.ID); err != nil {
+	// And members
+	if _, err := tx.Exec(`DELETE FROM members WHERE service_id = ?;`, service.ID); err != nil {
 		return err
 	}
+	for _, id := range service.Members {
+		if _, err := tx.Exec(`INSERT INTO members (service_id, member_id) VALUES (?, ?);`, service.ID, id); err != nil {
+			return err
+		}
+	}
 
-	// Delete history
-	if err := s.DeleteAllHistory(service); err != nil {
+	// And dependencies
+	if _, err := tx.Exec(`DELETE FROM dependencies WHERE service_id = ?;`, service.ID); err != nil {
 		return err
 	}
+	for _, id := range service.Parents {
+		if _, err := tx.Exec(`INSERT INTO dependencies (service_id, parent_id) VALUES (?, ?);`, service.ID, id); err != nil {
+			return err
+		}
+	}
 
+	// And steps, whose headers and assertions went with the service ones
+	for _, query := range []string{`DELETE FROM steps WHERE service_id = ?;`, `DELETE FROM extractions WHERE service_id = ?;`} {
+		if _, err := tx.Exec(query, service.ID); err != nil {
+			return err
+		}
+	}
+	for i, step := range service.Steps {
+		if _, err := tx.Exec(`INSERT INTO steps (service_id, position, name, method, endpoint, payload, preferred_status) VALUES (?, ?, ?, ?, ?, ?, ?);`, service.ID, i, step.Name, step.Method, step.Endpoint, step.Payload, step.PreferredStatus); err != nil {
+			return err
+		}
+		for _, h := range step.Headers {
+			if _, err := tx.Exec(`INSERT INTO headers (service_id, step, name, value) VALUES (?, ?, ?, ?);`, service.ID, i, h.Name, h.Value); err != nil {
+				return err
+			}
+		}
+		for _, e := range step.Extractions {
+			if _, err := tx.Exec(`INSERT INTO extractions (service_id, step, name, source, target) VALUES (?, ?, ?, ?, ?);`, service.ID, i, e.Name, e.Source, e.Target); err != nil {
+				return err
+			}
+		}
+		for _, a := range step.Assertions {
+			if _, err := tx.Exec(`INSERT INTO assertions (service_id, step, source, target, operator, value) VALUES (?, ?, ?, ?, ?, ?);`, service.ID, i, a.Source, a.Target, a.Operator, a.Value); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-// backupExistingDatabase checks if goardian.db exists and renames it to goardian.bak.db
-func (s *Store) backupExistingDatabase() error {
-	dbPath := "./goardian.db"
-	backupPath := "./goardian.bak.db"
+func (s *Store) SaveHistory(service Service, check Check) error {
+	var certExpiry any
+	if !check.CertExpiry.IsZero() {
+		certExpiry = check.CertExpiry.UTC().Format("2006-01-02 15:04:05")
+	}
+	observed := check.Observed
+	if observed == "" {
+		observed = check.Status
+	}
 
-	// Check if the database file exists
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		// Database doesn't exist, nothing to backup
-		return nil
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	// Remove existing backup if it exists
-	if _, err := os.Stat(backupPath); err == nil {
-		if err := os.Remove(backupPath); err != nil {
-			return fmt.Errorf("failed to remove existing backup: %w", err)
-		}
+	// The status column is the confirmed state, uptime is computed from it
+	// leaving out the unreachable checks
+	insertQuery := `INSERT INTO history (service_id, status, state, latency_ms, status_code, error_category, error_message, cert_expires_at, packets_sent, packets_lost, reply_latency_ms, step, observed_state, attempts) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	result, err := tx.Exec(insertQuery, service.ID, check.Status.Up(), check.Status, check.Latency.Milliseconds(), check.StatusCode, check.Category, check.Error, certExpiry, check.PacketsSent, check.PacketsLost, check.ReplyLatency.Milliseconds(), check.Step, observed, max(len(check.Attempts), 1))
+	if err != nil {
+		return err
 	}
 
-	// Rename current database to backup
-	if err := os.Rename(dbPath, backupPath); err != nil {
-		return fmt.Errorf("failed to rename database to backup: %w", err)
+	// Tries of a retried check, the last one being the check itself
+	if len(check.Attempts) > 0 {
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, a := range check.Attempts {
+			if _, err := tx.Exec(`INSERT INTO attempts (history_id, service_id, state, latency_ms, status_code, error_category, error_message, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`,
+				id, service.ID, a.Status, a.Latency.Milliseconds(), a.StatusCode, a.Category, a.Error, a.Time.UTC().Format("2006-01-02 15:04:05")); err != nil {
+				return err
+			}
+		}
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-// restoreFromBackup restores data from the backup database if it exists
-func (s *Store) restoreFromBackup() error {
-	backupPath := "./goardian.bak.db"
-
-	// Check if backup exists
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-		// No backup to restore from
-		return nil
+// GetHistory returns the latest checks of a service, newest first. A limit
+// of zero returns the whole history.
+func (s *Store) GetHistory(service Service, limit int) ([]Check, error) {
+	// Rows written before the detail columns existed only know up or down
+	query := `SELECT id, COALESCE(state, CASE WHEN status THEN 'online' ELSE 'offline' END), COALESCE(latency_ms, 0), COALESCE(status_code, 0), COALESCE(error_category, ''), COALESCE(error_message, ''), cert_expires_at, COALESCE(packets_sent, 0), COALESCE(packets_lost, 0), COALESCE(reply_latency_ms, 0), COALESCE(step, 0), observed_state, COALESCE(attempts, 1), timestamp
+	FROM history WHERE service_id = ? ORDER BY timestamp DESC, id DESC`
+	args := []any{service.ID}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
 	}
 
-	// Open backup database
-	backupConn, err := sql.Open("sqlite", backupPath)
+	rows, err := s.conn.Query(query, args...)
 	if err != nil {
-		return fmt.Errorf("failed to open backup database: %w", err)
+		return nil, err
 	}
-	defer backupConn.Close()
+	defer rows.Close()
 
-	// Get all services from backup
-	rows, err := backupConn.Query("SELECT * FROM services")
+	history := []Check{}
+	retried := map[int]int64{} // Index in history of the retried checks, by id
+	for rows.Next() {
+		var check Check
+		var id, latency, replyLatency int64
+		var attempts int
+		var certExpiry sql.NullTime
+		var observed sql.NullString
+		if err := rows.Scan(&id, &check.Status, &latency, &check.StatusCode, &check.Category, &check.Error, &certExpiry, &check.PacketsSent, &check.PacketsLost, &replyLatency, &check.Step, &observed, &attempts, &check.Time); err != nil {
+			return nil, err
+		}
+		check.Latency = time.Duration(latency) * time.Millisecond
+		check.ReplyLatency = time.Duration(replyLatency) * time.Millisecond
+		check.CertExpiry = certExpiry.Time
+		// Checks saved before confirmation existed were never overridden
+		check.Observed = Status(observed.String)
+		if check.Observed == "" {
+			check.Observed = check.Status
+		}
+		if attempts > 1 {
+			retried[len(history)] = id
+		}
+		history = append(history, check)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, id := range retried {
+		attempts, err := s.getAttempts(id)
+		if err != nil {
+			return nil, err
+		}
+		history[i].Attempts = attempts
+	}
+	return history, nil
+}
+
+// getAttempts returns the tries of a retried check, oldest first
+func (s *Store) getAttempts(historyID int64) ([]Check, error) {
+	rows, err := s.conn.Query(`SELECT state, latency_ms, status_code, error_category, error_message, timestamp FROM attempts WHERE history_id = ? ORDER BY id`, historyID)
 	if err != nil {
-		// If the table doesn't exist in backup, that's okay
-		return nil
+		return nil, err
 	}
 	defer rows.Close()
 
-	// Restore each service to the new database
+	attempts := []Check{}
 	for rows.Next() {
-		var service Service
-		err := rows.Scan(&service.ID, &service.Name, &service.Method, &service.Endpoint,
-			&service.Payload, &service.RequestDelay, &service.JSONProperty,
-			&service.ExpectedValue, &service.PreferredStatus, &service.InsecureSkipVerify)
-		if err != nil {
-			return fmt.Errorf("failed to scan service from backup: %w", err)
+		var a Check
+		var latency int64
+		if err := rows.Scan(&a.Status, &latency, &a.StatusCode, &a.Category, &a.Error, &a.Time); err != nil {
+			return nil, err
 		}
+		a.Latency = time.Duration(latency) * time.Millisecond
+		a.Observed = a.Status
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
 
-		// Insert service into new database
-		if err := s.SaveService(service); err != nil {
-			return fmt.Errorf("failed to restore service %s: %w", service.Name, err)
+// PruneHistory deletes the history of every service older than before
+func (s *Store) PruneHistory(before time.Time) error {
+	for _, table := range []string{"history", "attempts"} {
+		deleteQuery := `DELETE FROM ` + table + ` WHERE timestamp < ?;`
+		if _, err := s.conn.Exec(deleteQuery, before.UTC().Format("2006-01-02 15:04:05")); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Restore history from backup
-	historyRows, err := backupConn.Query("SELECT service_id, status, timestamp FROM history")
-	if err == nil {
-		defer historyRows.Close()
-		for historyRows.Next() {
-			var serviceID string
-			var status bool
-			var timestamp string
-			if err := historyRows.Scan(&serviceID, &status, &timestamp); err != nil {
-				return fmt.Errorf("failed to scan history from backup: %w", err)
-			}
-			_, err := s.conn.Exec(
-				`INSERT INTO history (service_id, status, timestamp) VALUES (?, ?, ?)`,
-				serviceID, status, timestamp,
-			)
-			if err != nil {
-				return fmt.Errorf("failed to restore history for service %s: %w", serviceID, err)
-			}
+// GetUptime returns the share of checks of a service since the given time in
+// which it was up, along with the number of checks. Checks made while a
+// service it depends on was down say nothing about the service itself and
+// count neither way.
+func (s *Store) GetUptime(service Service, since time.Time) (float64, int, error) {
+	var checks, up int
+	query := `SELECT COUNT(*), COALESCE(SUM(status), 0) FROM history WHERE service_id = ? AND timestamp >= ? AND state IS NOT ?;`
+	if err := s.conn.QueryRow(query, service.ID, since.UTC().Format("2006-01-02 15:04:05"), StatusUnreachable).Scan(&checks, &up); err != nil {
+		return 0, 0, err
+	}
+	if checks == 0 {
+		return 0, 0, nil
+	}
+	return float64(up) / float64(checks), checks, nil
+}
+
+// SaveAlertFailure records a webhook delivery that failed after every retry
+func (s *Store) SaveAlertFailure(service Service, webhook, event string, attempts int, err error) error {
+	insertQuery := `INSERT INTO alert_failures (service_id, webhook, event, attempts, error_message) VALUES (?, ?, ?, ?, ?);`
+	if _, err := s.conn.Exec(insertQuery, service.ID, webhook, event, attempts, truncate(err.Error(), maxErrorMessage)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) DeleteAllHistory(service Service) error {
+	for _, deleteQuery := range []string{`DELETE FROM history WHERE service_id = ?;`, `DELETE FROM attempts WHERE service_id = ?;`} {
+		if _, err := s.conn.Exec(deleteQuery, service.ID); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+func (s *Store) DeleteService(service Service) error {
+	if service.ID == "" {
+		// if not exists throw an error
+		err := fmt.Errorf("could not find service %v with ID: %v", service.Name, service.ID)
+		return err
+	}
 
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteService(tx, service); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteService removes a service and everything that refers to it within tx
+func deleteService(tx *sql.Tx, service Service) error {
+	deleteQueries := []string{
+		// Service
+		`DELETE FROM services WHERE id = ?;`,
+		// History
+		`DELETE FROM history WHERE service_id = ?;`,
+		`DELETE FROM attempts WHERE service_id = ?;`,
+		// Headers, assertions and steps
+		`DELETE FROM headers WHERE service_id = ?;`,
+		`DELETE FROM assertions WHERE service_id = ?;`,
+		`DELETE FROM steps WHERE service_id = ?;`,
+		`DELETE FROM extractions WHERE service_id = ?;`,
+		// Members, and the service from the composite services it's part of
+		`DELETE FROM members WHERE service_id = ?1 OR member_id = ?1;`,
+		// Dependencies, the service's children no longer depend on it
+		`DELETE FROM dependencies WHERE service_id = ?1 OR parent_id = ?1;`,
+		// Alert failures
+		`DELETE FROM alert_failures WHERE service_id = ?;`,
+	}
+	for _, deleteQuery := range deleteQueries {
+		if _, err := tx.Exec(deleteQuery, service.ID); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// recoverLegacyBackup puts back a database left renamed to goardian.bak.db
+// by a crash of an older version during startup. Those versions copied the
+// backup into a new goardian.db and kept it, so when both exist the backup
+// is stale unless goardian.db never got its tables: services and history
+// could have been deleted since it was made.
+func recoverLegacyBackup() error {
+	if _, err := os.Stat(legacyBackupPath); os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return os.Rename(legacyBackupPath, dbPath)
+	}
+
+	created, err := tableExists(dbPath, "services")
+	if err != nil {
+		return err
+	}
+	if !created {
+		if err := os.Rename(dbPath, partialRestorePath); err != nil {
+			return err
+		}
+		log.Printf("%s has no tables, restoring %s and keeping the empty database as %s", dbPath, legacyBackupPath, partialRestorePath)
+		return os.Rename(legacyBackupPath, dbPath)
+	}
+	log.Printf("%s was left by an older version and is no longer used, it can be removed", legacyBackupPath)
+	return nil
+}
+
+// tableExists reports whether the database at path has the given table
+func tableExists(path, table string) (bool, error) {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var n int
+	err = conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;`, table).Scan(&n)
+	return n > 0, err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bytes of a TCP banner read while waiting for it to match
+const maxBannerSize = 4096
+
+// getTCPStatus connects to a host:port endpoint, optionally writes the
+// payload and waits for a banner matching the expected regex.
+func getTCPStatus(ctx context.Context, s Service) Check {
+	check := Check{Time: time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, msDuration(s.Timeout, defaultTimeout))
+	defer cancel()
+
+	var banner *regexp.Regexp
+	if s.Banner != "" {
+		var err error
+		if banner, err = regexp.Compile(s.Banner); err != nil {
+			return check.fail(failureRequest, fmt.Errorf("invalid banner regex: %w", err))
+		}
+	}
+
+	start := time.Now()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Endpoint)
+	if err != nil {
+		check.Latency = time.Since(start)
+		return check.fail(classifyError(err), err)
+	}
+	defer conn.Close()
+
+	// Reads and writes share the check timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if s.Payload != "" {
+		if _, err := conn.Write([]byte(unescape(s.Payload))); err != nil {
+			check.Latency = time.Since(start)
+			return check.fail(classifyError(err), err)
+		}
+	}
+
+	if banner != nil {
+		received, err := readBanner(conn, banner)
+		check.Latency = time.Since(start)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) || ctx.Err() != nil {
+				return check.fail(failureBanner, fmt.Errorf("no banner matching %s within the timeout, got %q", s.Banner, received))
+			}
+			return check.fail(failureBanner, fmt.Errorf("banner doesn't match %s, got %q: %w", s.Banner, received, err))
+		}
+	} else {
+		check.Latency = time.Since(start)
+	}
+
+	return gradeLatency(s, check)
+}
+
+// readBanner reads from conn until what was received matches re, the
+// connection is closed or maxBannerSize bytes were read.
+func readBanner(conn net.Conn, re *regexp.Regexp) (string, error) {
+	received := []byte{}
+	buf := make([]byte, 512)
+	for len(received) < maxBannerSize {
+		n, err := conn.Read(buf)
+		received = append(received, buf[:n]...)
+		if re.Match(received) {
+			return string(received), nil
+		}
+		if err != nil {
+			return string(received), err
+		}
+	}
+	return string(received), fmt.Errorf("no match in the first %d bytes", maxBannerSize)
+}
+
+// unescape interprets Go escape sequences such as \r\n in a payload typed on
+// a single line, keeping it as is when it has none or they are invalid.
+func unescape(payload string) string {
+	if !strings.Contains(payload, `\`) {
+		return payload
+	}
+	unquoted, err := strconv.Unquote(`"` + strings.ReplaceAll(payload, `"`, `\"`) + `"`)
+	if err != nil {
+		return payload
+	}
+	return unquoted
+}
+
+// validTCPEndpoint reports whether endpoint is a host:port address
+func validTCPEndpoint(endpoint string) bool {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil || host == "" {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// startTCPServer serves a small line protocol on a local port: it greets
+// with an SMTP-like banner, answers PING with +PONG and QUIT with a goodbye
+// before closing, and records every line it receives. Other lines get no
+// answer.
+func startTCPServer(t *testing.T) (addr string, received func() []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	var lines []string
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("220 mail.test ESMTP ready\r\n"))
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					lines = append(lines, line)
+					mu.Unlock()
+					switch line {
+					case "PING\r\n":
+						conn.Write([]byte("+PONG\r\n"))
+					case "QUIT\r\n":
+						conn.Write([]byte("221 bye\r\n"))
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, lines...)
+	}
+}
+
+func TestTCPStatus(t *testing.T) {
+	addr, received := startTCPServer(t)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		endpoint string
+		payload  string
+		banner   string
+		want     Status
+		category string
+		err      string // Part of the error
+		sent     string // Line the server must have received
+	}{
+		{name: "connect", endpoint: addr, want: StatusOnline},
+		{name: "banner", endpoint: addr, banner: `^220 \S+ ESMTP`, want: StatusOnline},
+		{name: "banner mismatch", endpoint: addr, banner: `^554`, want: StatusOffline, category: failureBanner, err: `no banner matching ^554 within the timeout, got "220 mail.test ESMTP ready\r\n"`},
+		{name: "payload escapes", endpoint: addr, payload: `PING\r\n`, banner: `\+PONG`, want: StatusOnline, sent: "PING\r\n"},
+		{name: "closed before a match", endpoint: addr, payload: `QUIT\r\n`, banner: `^250`, want: StatusOffline, category: failureBanner, err: "banner doesn't match ^250", sent: "QUIT\r\n"},
+		{name: "payload kept without escapes", endpoint: addr, payload: "PING\n", banner: `\+PONG`, want: StatusOffline, category: failureBanner, sent: "PING\n"},
+		{name: "invalid banner", endpoint: addr, banner: `(`, want: StatusOffline, category: failureRequest},
+		{name: "refused", endpoint: closed.Addr().String(), want: StatusOffline, category: failureConnection},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Service{Type: typeTCP, Endpoint: tt.endpoint, Payload: tt.payload, Banner: tt.banner, Timeout: "300"}
+			check := getTCPStatus(context.Background(), s)
+			if check.Status != tt.want || check.Category != tt.category {
+				t.Fatalf("got %s (%s: %s), want %s (%s)", check.Status, check.Category, check.Error, tt.want, tt.category)
+			}
+			if !strings.Contains(check.Error, tt.err) {
+				t.Errorf("error = %q, want %q", check.Error, tt.err)
+			}
+			if tt.sent != "" {
+				lines := received()
+				if len(lines) == 0 || lines[len(lines)-1] != tt.sent {
+					t.Errorf("server received %q, want %q last", lines, tt.sent)
+				}
+			}
+		})
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+	}{
+		{`PING`, "PING"},
+		{`PING\r\n`, "PING\r\n"},
+		{`\x00\x01`, "\x00\x01"},
+		{`say "hi"\n`, "say \"hi\"\n"},
+		{`C:\path`, `C:\path`}, // Invalid escape, kept as is
+		{"line\n", "line\n"},
+	}
+	for _, tt := range tests {
+		if got := unescape(tt.payload); got != tt.want {
+			t.Errorf("unescape(%q) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 )
@@ -17,6 +21,38 @@ var (
 	helperStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("245")).Faint(true)
 )
 
+// statusColor is green when online, yellow when degraded, grey when
+// unreachable and red when offline
+func statusColor(status Status) lipgloss.Color {
+	switch status {
+	case StatusOnline:
+		return lipgloss.Color("2")
+	case StatusDegraded:
+		return lipgloss.Color("3")
+	case StatusUnreachable:
+		return lipgloss.Color("8")
+	default:
+		return lipgloss.Color("1")
+	}
+}
+
+func statusStyle(status Status) lipgloss.Style {
+	return lipgloss.NewStyle().Background(statusColor(status))
+}
+
+func statusLabel(status Status) string {
+	switch status {
+	case StatusOnline:
+		return "Online"
+	case StatusDegraded:
+		return "Degraded"
+	case StatusUnreachable:
+		return "Unreachable"
+	default:
+		return "Offline"
+	}
+}
+
 func (m model) View() string {
 	s := appNameStyle.Render("Welcome to goardian 🛡")
 	s += appSubStyle.Render("HTTP service health checker") + "\n\n"
@@ -27,6 +63,24 @@ func (m model) View() string {
 		s += helperStyle.Render("Enter service name") + "\n\n"
 	}
 
+	if m.state == dependenciesView {
+		s += "Depends on: \n\n"
+		for i, name := range memberNames(m.currService.Parents, m.services) {
+			s += listEnumeratorStyle.Render(strconv.Itoa(i+1)+".") + name + "\n"
+		}
+		if len(m.currService.Parents) > 0 {
+			s += "\n"
+		}
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter the name of a service this one needs to be reachable, e.g. a gateway (optional), -N to remove one, blank to continue") + "\n\n"
+	}
+
+	if m.state == typeView {
+		s += "Service type: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter service type (http, tcp, dns, icmp, grpc, websocket, multistep, composite, blank for http)") + "\n\n"
+	}
+
 	if m.state == methodView {
 		s += "Method: \n\n"
 		s += m.textinput.View() + "\n\n"
@@ -36,25 +90,244 @@ func (m model) View() string {
 	if m.state == endpointView {
 		s += "Endpoint: \n\n"
 		s += m.textinput.View() + "\n\n"
-		s += helperStyle.Render("Enter HTTP endpoint (http:// or https://)") + "\n\n"
+		switch m.currService.Type {
+		case typeTCP:
+			s += helperStyle.Render("Enter TCP address (host:port)") + "\n\n"
+		case typeDNS:
+			s += helperStyle.Render("Enter domain name to resolve") + "\n\n"
+		case typeICMP:
+			s += helperStyle.Render("Enter host name or IP address to ping") + "\n\n"
+		case typeGRPC:
+			s += helperStyle.Render("Enter gRPC server address (host:port)") + "\n\n"
+		case typeWebSocket:
+			s += helperStyle.Render("Enter WebSocket endpoint (ws:// or wss://)") + "\n\n"
+		default:
+			s += helperStyle.Render("Enter HTTP endpoint (http:// or https://)") + "\n\n"
+		}
+	}
+
+	if m.state == resolverView {
+		s += "Resolver: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter DNS server (host or host:port, blank for the system resolver)") + "\n\n"
+	}
+
+	if m.state == pingCountView {
+		s += "Packets: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render(fmt.Sprintf("Enter echo requests sent by each check (1-%d, blank for %d)", maxPingCount, defaultPingCount)) + "\n\n"
+	}
+
+	if m.state == grpcServiceView {
+		s += "Health service: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter service name passed to the health check (blank for the whole server)") + "\n\n"
+	}
+
+	if m.state == grpcTLSView {
+		s += "TLS: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter true/false (blank for false)") + "\n\n"
+	}
+
+	if m.state == stepsView {
+		s += "Steps: \n\n"
+		for i, step := range m.currService.Steps {
+			s += listEnumeratorStyle.Render(strconv.Itoa(i+1)+".") + step.String() + faint.Render(stepDetails(step)) + "\n"
+		}
+		if len(m.currService.Steps) > 0 {
+			s += "\n"
+		}
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter a step ([name] METHOD endpoint [statuses], blank statuses for 2xx, {{variable}} allowed), N to edit one, -N to remove one, blank to continue") + "\n\n"
+	}
+
+	if m.state == stepRequestView {
+		s += fmt.Sprintf("Step %d: \n\n", m.stepIndex+1)
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter step request ([name] METHOD endpoint [statuses])") + "\n\n"
+	}
+
+	if m.state == stepPayloadView {
+		s += fmt.Sprintf("Step %d payload: \n\n", m.stepIndex+1)
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter payload (JSON, form or plain text, {{variable}} allowed, optional)") + "\n\n"
+	}
+
+	if m.state == stepHeadersView {
+		s += fmt.Sprintf("Step %d headers: \n\n", m.stepIndex+1)
+		for _, h := range m.currService.Steps[m.stepIndex].Headers {
+			s += listEnumeratorStyle.Render("-") + h.Name + ": " + faint.Render(h.Value) + "\n"
+		}
+		if len(m.currService.Steps[m.stepIndex].Headers) > 0 {
+			s += "\n"
+		}
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter a header (Name: value, {{variable}} allowed), Name: to remove it, blank to continue") + "\n\n"
+	}
+
+	if m.state == extractionsView {
+		s += fmt.Sprintf("Step %d extractions: \n\n", m.stepIndex+1)
+		for i, e := range m.currService.Steps[m.stepIndex].Extractions {
+			s += listEnumeratorStyle.Render(strconv.Itoa(i+1)+".") + e.String() + "\n"
+		}
+		if len(m.currService.Steps[m.stepIndex].Extractions) > 0 {
+			s += "\n"
+		}
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter a value for the next steps (name = json <path>, name = header <name> or name = regex <pattern>), -N to remove one, blank to continue") + "\n\n"
+	}
+
+	if m.state == stepAssertionsView {
+		s += fmt.Sprintf("Step %d assertions: \n\n", m.stepIndex+1)
+		for i, a := range m.currService.Steps[m.stepIndex].Assertions {
+			s += listEnumeratorStyle.Render(strconv.Itoa(i+1)+".") + a.String() + "\n"
+		}
+		if len(m.currService.Steps[m.stepIndex].Assertions) > 0 {
+			s += "\n"
+		}
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter an assertion (json <path> <operator> <value>, header <name> <==, regex, exists> <value> or body <contains, !contains, regex> <value>), -N to remove one, blank to finish the step") + "\n\n"
+	}
+
+	if m.state == membersView {
+		s += "Members: \n\n"
+		for i, name := range memberNames(m.currService.Members, m.services) {
+			s += listEnumeratorStyle.Render(strconv.Itoa(i+1)+".") + name + "\n"
+		}
+		if len(m.currService.Members) > 0 {
+			s += "\n"
+		}
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter the name of a service this one is made of, -N to remove one, blank to continue") + "\n\n"
+	}
+
+	if m.state == ruleView {
+		s += "Rule: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render(fmt.Sprintf("Enter members that must be up (all, any or a number up to %d, blank for all)", len(m.currService.Members))) + "\n\n"
+	}
+
+	if m.state == recordTypeView {
+		s += "Record type: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter record type ("+strings.Join(dnsRecordTypes, ", ")+", blank for "+defaultRecordType+")") + "\n\n"
 	}
 
 	if m.state == payloadView {
 		s += "Payload: \n\n"
 		s += m.textinput.View() + "\n\n"
-		s += helperStyle.Render("Enter HTTP payload (JSON)") + "\n\n"
+		if m.currService.Type == typeTCP {
+			s += helperStyle.Render("Enter text sent after connecting, escapes such as \\r\\n allowed (optional)") + "\n\n"
+		} else if m.currService.Type == typeWebSocket {
+			s += helperStyle.Render("Enter message sent after the handshake, its first reply is asserted on (optional)") + "\n\n"
+		} else {
+			s += helperStyle.Render("Enter HTTP payload (JSON, form or plain text)") + "\n\n"
+		}
+	}
+
+	if m.state == bannerView {
+		s += "Banner: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter regex the banner sent by the server must match (optional)") + "\n\n"
+	}
+
+	if m.state == headersView {
+		if m.currService.Type == typeGRPC {
+			s += "Metadata: \n\n"
+		} else {
+			s += "Headers: \n\n"
+		}
+		for _, h := range m.currService.Headers {
+			s += listEnumeratorStyle.Render("-") + h.Name + ": " + faint.Render(h.Value) + "\n"
+		}
+		if len(m.currService.Headers) > 0 {
+			s += "\n"
+		}
+		s += m.textinput.View() + "\n\n"
+		if m.currService.Type == typeGRPC {
+			s += helperStyle.Render("Enter a metadata entry (name: value), name: to remove it, blank to continue") + "\n\n"
+		} else {
+			s += helperStyle.Render("Enter a header (Name: value), Name: to remove it, blank to continue") + "\n\n"
+		}
+	}
+
+	if m.state == authTypeView {
+		s += "Authentication: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter auth type (none, basic, bearer, apikey)") + "\n\n"
+	}
+
+	if m.state == authUsernameView {
+		s += "Username: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter basic auth username") + "\n\n"
+	}
+
+	if m.state == authHeaderView {
+		s += "API key header: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter header carrying the API key (blank for "+defaultAPIKeyHeader+")") + "\n\n"
+	}
+
+	if m.state == authSecretView {
+		s += "Secret: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter password, bearer token or API key") + "\n\n"
 	}
 
 	if m.state == requestDelayView {
-		s += "Request delay: \n\n"
+		s += "Check interval: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter check interval (milliseconds, blank for 5000)") + "\n\n"
+	}
+
+	if m.state == retriesView {
+		s += "Retries: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render(fmt.Sprintf("Enter immediate retries of a failed check (0-%d, blank for none)", maxRetries)) + "\n\n"
+	}
+
+	if m.state == retryBackoffView {
+		s += "Retry backoff: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render(fmt.Sprintf("Enter wait before the first retry, doubled after each one (milliseconds, blank for %d)", defaultRetryBackoff.Milliseconds())) + "\n\n"
+	}
+
+	if m.state == failureThresholdView {
+		s += "Failure threshold: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render(fmt.Sprintf("Enter consecutive failed checks to mark the service offline (1-%d, blank for %d)", maxConfirmThreshold, defaultFailureThreshold)) + "\n\n"
+	}
+
+	if m.state == recoveryThresholdView {
+		s += "Recovery threshold: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render(fmt.Sprintf("Enter consecutive passed checks to mark the service up again (1-%d, blank for %d)", maxConfirmThreshold, defaultRecoveryThreshold)) + "\n\n"
+	}
+
+	if m.state == timeoutView {
+		s += "Timeout: \n\n"
 		s += m.textinput.View() + "\n\n"
-		s += helperStyle.Render("Enter HTTP request delay (milliseconds)") + "\n\n"
+		s += helperStyle.Render("Enter request timeout (milliseconds, blank for 10000)") + "\n\n"
+	}
+
+	if m.state == degradedThresholdView {
+		s += "Degraded threshold: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter response time to mark the service degraded (milliseconds, blank to disable)") + "\n\n"
+	}
+
+	if m.state == downThresholdView {
+		s += "Down threshold: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render("Enter response time to mark the service offline (milliseconds, blank to disable)") + "\n\n"
 	}
 
 	if m.state == jsonPropertyView {
 		s += "JSON Property: \n\n"
 		s += m.textinput.View() + "\n\n"
-		s += helperStyle.Render("Enter JSON property (my.property.key)") + "\n\n"
+		s += helperStyle.Render("Enter JSON property (my.property.key or a JSONPath like $.checks[0].status)") + "\n\n"
 	}
 
 	if m.state == expectedValueView {
@@ -63,10 +336,28 @@ func (m model) View() string {
 		s += helperStyle.Render("Enter expected JSON Property value (blank for any value)") + "\n\n"
 	}
 
+	if m.state == assertionsView {
+		s += "Assertions: \n\n"
+		for i, a := range m.currService.Assertions {
+			s += listEnumeratorStyle.Render(strconv.Itoa(i+1)+".") + a.String() + "\n"
+		}
+		if len(m.currService.Assertions) > 0 {
+			s += "\n"
+		}
+		s += m.textinput.View() + "\n\n"
+		if m.currService.Type == typeWebSocket {
+			s += helperStyle.Render("Enter an assertion on the first reply (json <path> <operator> <value> or body <contains, !contains, regex> <value>), -N to remove one, blank to continue") + "\n\n"
+		} else if m.currService.Type == typeDNS {
+			s += helperStyle.Render("Enter an assertion (dns answer <contains, !contains, regex> <value>, dns count <operator> <n> or dns ttl <operator> <seconds>), -N to remove one, blank to save") + "\n\n"
+		} else {
+			s += helperStyle.Render("Enter an assertion (json <path> <operator> <value>, header <name> <==, regex, exists> <value> or body <contains, !contains, regex> <value>), -N to remove one, blank to continue") + "\n\n"
+		}
+	}
+
 	if m.state == preferredStatusView {
 		s += "Preferred Status: \n\n"
 		s += m.textinput.View() + "\n\n"
-		s += helperStyle.Render("Enter preferred HTTP status (100-599)") + "\n\n"
+		s += helperStyle.Render("Enter accepted HTTP statuses: codes, classes or ranges separated by commas (e.g. 200,204, 2xx or 200-299)") + "\n\n"
 	}
 
 	if m.state == insecureSkipVerifyView {
@@ -75,13 +366,25 @@ func (m model) View() string {
 		s += helperStyle.Render("Enter true/false") + "\n\n"
 	}
 
+	if m.state == certWarningDaysView {
+		s += "Certificate Warning: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render(fmt.Sprintf("Enter days before certificate expiry to mark the service degraded (blank for %d, 0 to disable)", defaultCertWarningDays)) + "\n\n"
+	}
+
+	if m.state == certCriticalDaysView {
+		s += "Certificate Critical: \n\n"
+		s += m.textinput.View() + "\n\n"
+		s += helperStyle.Render(fmt.Sprintf("Enter days before certificate expiry to mark the service offline (blank for %d, 0 to disable)", defaultCertCriticalDays)) + "\n\n"
+	}
+
 	if m.errorMsg != "" {
 		s += errorMessageStyle.Render(m.errorMsg) + "\n\n"
 	}
 
 	if m.state == nameView {
 		s += faint.Render("enter = save | esc = cancel")
-	} else if m.state != listView {
+	} else if m.state != listView && m.state != detailView {
 		s += faint.Render("enter = continue | esc = go back")
 	}
 
@@ -92,20 +395,378 @@ func (m model) View() string {
 				prefix = ">"
 			}
 			shortEndpoint := strings.ReplaceAll(o.Endpoint, "\n", " ")
+			if o.Type == typeComposite {
+				shortEndpoint = ruleLabel(o.Rule, len(o.Members)) + " up: " + strings.Join(memberNames(o.Members, m.services), ", ")
+			}
 			if len(shortEndpoint) > 60 {
 				shortEndpoint = shortEndpoint[:60] + "..."
 			}
-			s += listEnumeratorStyle.Render(prefix) + o.Name + " | " + faint.Render(shortEndpoint) + "\n\n"
+			// Services are listed under the first service they depend on
+			indent := ""
+			if depth := dependencyDepth(o, m.services); depth > 0 {
+				indent = strings.Repeat("  ", depth-1) + faint.Render("└ ")
+			}
+			s += listEnumeratorStyle.Render(prefix) + indent + o.Name + " | " + faint.Render(shortEndpoint) + "\n\n"
 			if o.LastStatusInfo == "" {
 				s += "Waiting" + m.spinner.View() + "\n\n"
 			} else {
 				s += o.LastStatusInfo + " " + m.pulseSpinner.View() + "\n\n"
 			}
 		}
-		s += faint.Render("n - new service | q - quit | d - delete | ctrl + r - restart history")
+		s += faint.Render("n - new service | enter - details | e - edit | q - quit | d - delete | ctrl + r - restart history")
+	}
+
+	if m.state == detailView {
+		s += m.detailView()
+		s += faint.Render("e - edit | esc - back")
 	}
 
 	s += "\n\n" + helperStyle.Render("goardian v1.0.0 by DavidArtifacts")
 	s += "\n\n" + helperStyle.Render("GitHub: https://github.com/DigitalArtifactory/goardian") + "\n\n"
 	return s
 }
+
+// detailView renders the history and configuration of the selected service
+func (m model) detailView() string {
+	o := m.services[m.listIndex]
+	d := m.detail
+
+	target := o.Method + " " + o.Endpoint
+	switch o.Type {
+	case typeTCP:
+		target = "TCP " + o.Endpoint
+	case typeDNS:
+		target = "DNS " + orDefault(o.RecordType, defaultRecordType) + " " + o.Endpoint
+	case typeICMP:
+		target = "ICMP " + o.Endpoint
+	case typeGRPC:
+		target = "gRPC " + o.Endpoint
+	case typeWebSocket:
+		target = "WebSocket " + o.Endpoint
+	case typeMultiStep:
+		target = fmt.Sprintf("%d steps from %s", len(o.Steps), o.Endpoint)
+	case typeComposite:
+		target = fmt.Sprintf("Composite of %d services, %s must be up", len(o.Members), ruleLabel(o.Rule, len(o.Members)))
+	}
+	s := o.Name + " | " + faint.Render(target) + "\n\n"
+	if o.LastStatusInfo == "" {
+		s += "Waiting" + m.spinner.View() + "\n\n"
+	} else {
+		s += o.LastStatusInfo + "\n\n"
+	}
+
+	// Uptime
+	uptime := []string{}
+	for i, w := range uptimeWindows {
+		value := "n/a"
+		if d.uptime[i] != nil {
+			value = fmt.Sprintf("%.2f%%", *d.uptime[i]*100)
+		}
+		uptime = append(uptime, w.label+" "+value)
+	}
+	s += "Uptime: " + strings.Join(uptime, " | ") + "\n\n"
+
+	// Certificate of the latest check that saw one
+	for _, c := range d.history {
+		if c.CertExpiry.IsZero() {
+			continue
+		}
+		days := certDays(c.CertExpiry)
+		label := fmt.Sprintf("expires in %d days", days)
+		if days < 0 {
+			label = fmt.Sprintf("expired %d days ago", -days)
+		}
+		s += "Certificate: " + label + " " + faint.Render("("+c.CertExpiry.Local().Format("2006-01-02 15:04")+")") + "\n\n"
+		break
+	}
+
+	// Latency sparkline, oldest check first
+	if len(d.history) > 0 {
+		var maxLatency time.Duration
+		for _, c := range d.history {
+			maxLatency = max(maxLatency, c.Latency)
+		}
+		s += fmt.Sprintf("Latency (last %d checks, max %v): \n\n", len(d.history), maxLatency.Round(time.Millisecond))
+		s += sparkline(d.history, maxLatency) + "\n\n"
+	}
+
+	// Recent checks
+	s += "Recent checks: \n\n"
+	if len(d.history) == 0 {
+		s += faint.Render("No checks yet") + "\n"
+	}
+	for i, c := range d.history {
+		if i == detailLogLimit {
+			break
+		}
+		code := "-"
+		if c.StatusCode != 0 {
+			code = strconv.Itoa(c.StatusCode)
+		}
+		line := fmt.Sprintf("%s %-8s %3s %6v", c.Time.Local().Format("2006-01-02 15:04:05"),
+			statusLabel(c.Status), code, c.Latency.Round(time.Millisecond))
+		if c.ReplyLatency > 0 {
+			line += fmt.Sprintf(" reply %v", c.ReplyLatency.Round(time.Millisecond))
+		}
+		if len(c.Attempts) > 1 {
+			tries := []string{}
+			for _, a := range c.Attempts {
+				tries = append(tries, string(a.Status))
+			}
+			line += " attempts " + strings.Join(tries, ", ")
+		}
+		if c.Observed != "" && c.Observed != c.Status {
+			line += " unconfirmed " + string(c.Observed)
+		}
+		s += lipgloss.NewStyle().Foreground(statusColor(c.Status)).Render("■") + " " + line
+		if c.Category != "" {
+			s += " " + errorMessageStyle.Render(c.Category+": "+c.Error)
+		}
+		s += "\n"
+	}
+	s += "\n"
+
+	// Configuration
+	s += "Configuration: \n\n"
+	fields := serviceConfig(o, m.services)
+	if o.Type != typeComposite {
+		retries := "none"
+		if n := retryCount(o.Retries); n > 0 {
+			retries = fmt.Sprintf("%d, backoff %v", n, msDuration(o.RetryBackoff, defaultRetryBackoff))
+		}
+		fields = append(fields, [2]string{"Retries", retries})
+	}
+	fields = append(fields, [2]string{"Confirmation", fmt.Sprintf("%d failed checks to go offline, %d passed to recover",
+		confirmThreshold(o.FailureThreshold, defaultFailureThreshold), confirmThreshold(o.RecoveryThreshold, defaultRecoveryThreshold))})
+	if len(o.Parents) > 0 {
+		fields = append(fields, [2]string{"Depends on", strings.Join(memberNames(o.Parents, m.services), ", ")})
+	}
+	if children := dependents(o, m.services); len(children) > 0 {
+		fields = append(fields, [2]string{"Required by", strings.Join(children, ", ")})
+	}
+	for _, field := range fields {
+		s += listEnumeratorStyle.Render("-") + field[0] + ": " + faint.Render(field[1]) + "\n"
+	}
+	s += "\n"
+
+	return s
+}
+
+// sparkline plots the latency of checks, oldest first, with block
+// characters scaled to maxLatency and colored by status
+func sparkline(history []Check, maxLatency time.Duration) string {
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	s := ""
+	for i := len(history) - 1; i >= 0; i-- {
+		c := history[i]
+		level := 0
+		if maxLatency > 0 {
+			level = int(int64(c.Latency) * int64(len(blocks)-1) / int64(maxLatency))
+		}
+		s += lipgloss.NewStyle().Foreground(statusColor(c.Status)).Render(string(blocks[level]))
+	}
+	return s
+}
+
+// serviceConfig lists the configuration of a service as label and value
+// pairs, leaving secrets out. Services name the members of composite ones.
+func serviceConfig(o Service, services []Service) [][2]string {
+	threshold := func(value string) string {
+		if d := msDuration(value, 0); d > 0 {
+			return d.String()
+		}
+		return "disabled"
+	}
+	certThreshold := func(value string, def int) string {
+		if days := daysValue(value, def); days > 0 {
+			return fmt.Sprintf("%d days", days)
+		}
+		return "disabled"
+	}
+
+	headers := []string{}
+	for _, h := range o.Headers {
+		headers = append(headers, h.Name)
+	}
+
+	assertions := []string{}
+	for _, a := range o.Assertions {
+		assertions = append(assertions, a.String())
+	}
+
+	jsonProperty := orDefault(o.JSONProperty, "none")
+	if o.JSONProperty != "" && o.ExpectedValue != "" {
+		jsonProperty += " = " + o.ExpectedValue
+	}
+
+	switch o.Type {
+	case typeComposite:
+		return [][2]string{
+			{"Type", typeComposite},
+			{"Members", strings.Join(memberNames(o.Members, services), ", ")},
+			{"Rule", ruleLabel(o.Rule, len(o.Members)) + " up"},
+			{"Check interval", checkInterval(o).String()},
+			{"Managed by config file", orDefault(o.Managed, "false")},
+		}
+	case typeMultiStep:
+		fields := [][2]string{{"Type", typeMultiStep}}
+		for i, step := range o.Steps {
+			fields = append(fields, [2]string{fmt.Sprintf("Step %d", i+1), step.String() + stepDetails(step)})
+		}
+		return append(fields, [][2]string{
+			{"Check interval", checkInterval(o).String()},
+			{"Timeout", msDuration(o.Timeout, defaultTimeout).String()},
+			{"Degraded threshold", threshold(o.DegradedThreshold)},
+			{"Down threshold", threshold(o.DownThreshold)},
+			{"Insecure skip verify", orDefault(o.InsecureSkipVerify, "false")},
+			{"Certificate warning", certThreshold(o.CertWarningDays, defaultCertWarningDays)},
+			{"Certificate critical", certThreshold(o.CertCriticalDays, defaultCertCriticalDays)},
+			{"Managed by config file", orDefault(o.Managed, "false")},
+		}...)
+	case typeICMP:
+		return [][2]string{
+			{"Type", typeICMP},
+			{"Host", o.Endpoint},
+			{"Packets", strconv.Itoa(pingCount(o.PingCount))},
+			{"Check interval", checkInterval(o).String()},
+			{"Timeout", msDuration(o.Timeout, defaultTimeout).String()},
+			{"Degraded threshold", threshold(o.DegradedThreshold)},
+			{"Down threshold", threshold(o.DownThreshold)},
+			{"Managed by config file", orDefault(o.Managed, "false")},
+		}
+	case typeDNS:
+		return [][2]string{
+			{"Type", typeDNS},
+			{"Name", o.Endpoint},
+			{"Resolver", orDefault(o.Resolver, "system")},
+			{"Record type", orDefault(o.RecordType, defaultRecordType)},
+			{"Assertions", orDefault(strings.Join(assertions, "; "), "none")},
+			{"Check interval", checkInterval(o).String()},
+			{"Timeout", msDuration(o.Timeout, defaultTimeout).String()},
+			{"Degraded threshold", threshold(o.DegradedThreshold)},
+			{"Down threshold", threshold(o.DownThreshold)},
+			{"Managed by config file", orDefault(o.Managed, "false")},
+		}
+	case typeWebSocket:
+		return [][2]string{
+			{"Type", typeWebSocket},
+			{"Endpoint", o.Endpoint},
+			{"Message", orDefault(o.Payload, "none")},
+			{"Headers", orDefault(strings.Join(headers, ", "), "none")},
+			{"Authentication", orDefault(o.AuthType, authNone)},
+			{"Check interval", checkInterval(o).String()},
+			{"Timeout", msDuration(o.Timeout, defaultTimeout).String()},
+			{"Degraded threshold", threshold(o.DegradedThreshold)},
+			{"Down threshold", threshold(o.DownThreshold)},
+			{"JSON property", jsonProperty},
+			{"Assertions", orDefault(strings.Join(assertions, "; "), "none")},
+			{"Insecure skip verify", orDefault(o.InsecureSkipVerify, "false")},
+			{"Certificate warning", certThreshold(o.CertWarningDays, defaultCertWarningDays)},
+			{"Certificate critical", certThreshold(o.CertCriticalDays, defaultCertCriticalDays)},
+			{"Managed by config file", orDefault(o.Managed, "false")},
+		}
+	case typeGRPC:
+		return [][2]string{
+			{"Type", typeGRPC},
+			{"Endpoint", o.Endpoint},
+			{"Health service", orDefault(o.GRPCService, "server")},
+			{"TLS", orDefault(o.GRPCTLS, "false")},
+			{"Insecure skip verify", orDefault(o.InsecureSkipVerify, "false")},
+			{"Metadata", orDefault(strings.Join(headers, ", "), "none")},
+			{"Check interval", checkInterval(o).String()},
+			{"Timeout", msDuration(o.Timeout, defaultTimeout).String()},
+			{"Degraded threshold", threshold(o.DegradedThreshold)},
+			{"Down threshold", threshold(o.DownThreshold)},
+			{"Certificate warning", certThreshold(o.CertWarningDays, defaultCertWarningDays)},
+			{"Certificate critical", certThreshold(o.CertCriticalDays, defaultCertCriticalDays)},
+			{"Managed by config file", orDefault(o.Managed, "false")},
+		}
+	case typeTCP:
+		return [][2]string{
+			{"Type", typeTCP},
+			{"Endpoint", o.Endpoint},
+			{"Send", orDefault(o.Payload, "none")},
+			{"Banner", orDefault(o.Banner, "none")},
+			{"Check interval", checkInterval(o).String()},
+			{"Timeout", msDuration(o.Timeout, defaultTimeout).String()},
+			{"Degraded threshold", threshold(o.DegradedThreshold)},
+			{"Down threshold", threshold(o.DownThreshold)},
+			{"Managed by config file", orDefault(o.Managed, "false")},
+		}
+	}
+
+	return [][2]string{
+		{"Type", typeHTTP},
+		{"Method", orDefault(o.Method, "GET")},
+		{"Endpoint", o.Endpoint},
+		{"Payload", orDefault(o.Payload, "none")},
+		{"Headers", orDefault(strings.Join(headers, ", "), "none")},
+		{"Authentication", orDefault(o.AuthType, authNone)},
+		{"Check interval", checkInterval(o).String()},
+		{"Timeout", msDuration(o.Timeout, defaultTimeout).String()},
+		{"Degraded threshold", threshold(o.DegradedThreshold)},
+		{"Down threshold", threshold(o.DownThreshold)},
+		{"JSON property", jsonProperty},
+		{"Assertions", orDefault(strings.Join(assertions, "; "), "none")},
+		{"Preferred status", orDefault(o.PreferredStatus, "200")},
+		{"Insecure skip verify", orDefault(o.InsecureSkipVerify, "false")},
+		{"Certificate warning", certThreshold(o.CertWarningDays, defaultCertWarningDays)},
+		{"Certificate critical", certThreshold(o.CertCriticalDays, defaultCertCriticalDays)},
+		{"Managed by config file", orDefault(o.Managed, "false")},
+	}
+}
+
+// memberNames returns the names of services by ID, e.g. the members of a
+// composite service
+func memberNames(members []string, services []Service) []string {
+	names := []string{}
+	for _, id := range members {
+		i := slices.IndexFunc(services, func(s Service) bool { return s.ID == id })
+		if i < 0 {
+			names = append(names, "unknown")
+			continue
+		}
+		names = append(names, services[i].Name)
+	}
+	return names
+}
+
+// ruleLabel describes the rule of a composite service with n members
+func ruleLabel(rule string, n int) string {
+	switch rule {
+	case "", ruleAll:
+		return "all"
+	case ruleAny:
+		return "any"
+	}
+	return fmt.Sprintf("%d of %d", quorum(rule, n), n)
+}
+
+// stepDetails summarizes what a step sends and checks besides its request
+func stepDetails(step Step) string {
+	details := []string{}
+	if step.Payload != "" {
+		details = append(details, "payload")
+	}
+	if len(step.Headers) > 0 {
+		details = append(details, fmt.Sprintf("%d headers", len(step.Headers)))
+	}
+	for _, e := range step.Extractions {
+		details = append(details, "extracts "+e.Name)
+	}
+	if len(step.Assertions) > 0 {
+		details = append(details, fmt.Sprintf("%d assertions", len(step.Assertions)))
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(details, ", ") + ")"
+}
+
+// orDefault returns value, or def when it is blank
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
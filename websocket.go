@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// getWebSocketStatus completes the upgrade handshake with a ws:// or wss://
+// endpoint, then sends the payload as a text message and checks the first
+// reply with the body and json assertions. Without a payload or assertions
+// the handshake alone is checked.
+func getWebSocketStatus(ctx context.Context, s Service) Check {
+	check := Check{Time: time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, msDuration(s.Timeout, defaultTimeout))
+	defer cancel()
+
+	// The handshake carries the headers and auth of an HTTP request
+	handshake := s
+	handshake.Method, handshake.Payload = "GET", ""
+	req, err := newRequest(ctx, handshake)
+	if err != nil {
+		return check.fail(failureRequest, err)
+	}
+	if req.Host != req.URL.Host {
+		req.Header.Set("Host", req.Host)
+	}
+
+	dialer := websocket.Dialer{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify == "true"},
+	}
+	start := time.Now()
+	conn, resp, err := dialer.DialContext(ctx, s.Endpoint, req.Header)
+	check.Latency = time.Since(start)
+	if resp != nil {
+		check.StatusCode = resp.StatusCode
+	}
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			check.CertExpiry = chainExpiry(certErr.UnverifiedCertificates)
+		}
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return check.fail(failureStatusCode, fmt.Errorf("expected status 101, got %d", resp.StatusCode))
+		}
+		return check.fail(classifyError(err), err)
+	}
+	defer closeWebSocket(conn)
+
+	if tlsConn, ok := conn.UnderlyingConn().(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		chain := state.PeerCertificates
+		if len(state.VerifiedChains) > 0 {
+			chain = state.VerifiedChains[0]
+		}
+		check.CertExpiry = chainExpiry(chain)
+	}
+
+	if s.Payload == "" && !s.hasContentAssertions() {
+		return gradeCertificate(s, gradeLatency(s, check))
+	}
+
+	// Writing and waiting for the reply share the check timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+		conn.SetReadDeadline(deadline)
+	}
+	sent := time.Now()
+	if s.Payload != "" {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(s.Payload)); err != nil {
+			return check.fail(classifyError(err), err)
+		}
+	}
+	_, reply, err := conn.ReadMessage()
+	check.ReplyLatency = time.Since(sent)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return check.fail(failureReply, errors.New("no reply within the timeout"))
+		}
+		return check.fail(failureReply, fmt.Errorf("no reply: %w", err))
+	}
+	if category, err := s.evalContent(reply); err != nil {
+		return check.fail(category, err)
+	}
+
+	return gradeCertificate(s, gradeLatency(s, check))
+}
+
+// closeWebSocket says goodbye with a close frame before dropping the
+// connection, so servers don't log an abnormal closure for every check.
+func closeWebSocket(conn *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// validWebSocketEndpoint reports whether endpoint is a ws:// or wss:// URL
+func validWebSocketEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "ws://") || strings.HasPrefix(endpoint, "wss://")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// replyDelay is how long the test server waits before answering a message
+const replyDelay = 100 * time.Millisecond
+
+// parseAssertions parses assertion lines, failing the test on an invalid one
+func parseAssertions(t *testing.T, lines ...string) []Assertion {
+	t.Helper()
+	assertions := []Assertion{}
+	for _, line := range lines {
+		a, err := parseAssertion(line)
+		if err != nil {
+			t.Fatalf("parseAssertion(%q): %v", line, err)
+		}
+		assertions = append(assertions, a)
+	}
+	return assertions
+}
+
+func TestWebSocketStatus(t *testing.T) {
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	// Answers "ping" with a JSON pong after replyDelay, ignores anything else
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(msg) == "ping" {
+				time.Sleep(replyDelay)
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "pong", "seq": 1}`))
+			}
+		}
+	})
+	// Greets right after the handshake, without waiting for a message
+	mux.HandleFunc("/greet", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte("welcome"))
+		conn.ReadMessage()
+	})
+	// Closes right after the handshake
+	mux.HandleFunc("/hangup", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not a websocket"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	base := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	tests := []struct {
+		name       string
+		path       string
+		secret     string
+		payload    string
+		assertions []string
+		want       Status
+		category   string
+		statusCode int
+		replied    bool // Whether the reply latency is measured
+	}{
+		{name: "handshake", path: "/ws", secret: "t0k3n", want: StatusOnline, statusCode: 101},
+		{name: "reply", path: "/ws", secret: "t0k3n", payload: "ping", want: StatusOnline, statusCode: 101, replied: true},
+		{name: "reply assertions", path: "/ws", secret: "t0k3n", payload: "ping", assertions: []string{"body contains pong", "json $.seq == 1"}, want: StatusOnline, statusCode: 101, replied: true},
+		{name: "failed body assertion", path: "/ws", secret: "t0k3n", payload: "ping", assertions: []string{"body contains error"}, want: StatusOffline, category: failureBody, statusCode: 101, replied: true},
+		{name: "failed json assertion", path: "/ws", secret: "t0k3n", payload: "ping", assertions: []string{`json $.type == "ack"`}, want: StatusOffline, category: failureJSON, statusCode: 101, replied: true},
+		{name: "reply without payload", path: "/greet", assertions: []string{"body contains welcome"}, want: StatusOnline, statusCode: 101, replied: true},
+		{name: "no reply", path: "/ws", secret: "t0k3n", payload: "hello", want: StatusOffline, category: failureReply, statusCode: 101, replied: true},
+		{name: "closed", path: "/hangup", payload: "ping", want: StatusOffline, category: failureReply, statusCode: 101, replied: true},
+		{name: "unauthorized", path: "/ws", want: StatusOffline, category: failureStatusCode, statusCode: 401},
+		{name: "not upgraded", path: "/plain", want: StatusOffline, category: failureStatusCode, statusCode: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Service{
+				Type:               typeWebSocket,
+				Endpoint:           base + tt.path,
+				Payload:            tt.payload,
+				Timeout:            "500",
+				InsecureSkipVerify: "false",
+				AuthType:           authNone,
+				Assertions:         parseAssertions(t, tt.assertions...),
+			}
+			if tt.secret != "" {
+				s.AuthType, s.AuthSecret = authBearer, tt.secret
+			}
+
+			check := getWebSocketStatus(context.Background(), s)
+			if check.Status != tt.want || check.Category != tt.category {
+				t.Fatalf("got %s (%s: %s), want %s (%s)", check.Status, check.Category, check.Error, tt.want, tt.category)
+			}
+			if check.StatusCode != tt.statusCode {
+				t.Errorf("status code = %d, want %d", check.StatusCode, tt.statusCode)
+			}
+			if tt.category == failureStatusCode && !strings.Contains(check.Error, "expected status 101") {
+				t.Errorf("error = %q, want an unexpected status", check.Error)
+			}
+			if !tt.replied && check.ReplyLatency != 0 {
+				t.Errorf("reply latency = %v without a reply", check.ReplyLatency)
+			}
+			if tt.payload == "ping" && tt.path == "/ws" {
+				// The reply delay counts in the reply latency only
+				if check.ReplyLatency < replyDelay || check.Latency >= replyDelay {
+					t.Errorf("handshake took %v and reply %v, want the %v delay in the reply only", check.Latency, check.ReplyLatency, replyDelay)
+				}
+			}
+		})
+	}
+}